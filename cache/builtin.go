@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/udhos/oauth2/cache/errorcache"
+	"github.com/udhos/oauth2/cache/filecache"
+	"github.com/udhos/oauth2/cache/rediscache"
+	"github.com/udhos/oauth2/token"
+)
+
+func init() {
+	Register("error", newErrorCache)
+	Register("file", newFileCache)
+	Register("redis", newRedisCache)
+	Register("rediss", newRedisCache)
+}
+
+// newErrorCache creates errorcache from spec "error".
+func newErrorCache(_ Options) (token.TokenCache, error) {
+	return errorcache.New()
+}
+
+// newFileCache creates filecache from spec "file:<path>"
+// or "file:///<path>?mode=<octal>".
+func newFileCache(options Options) (token.TokenCache, error) {
+	if !isURL(options) {
+		return filecache.New(options.Rest) // legacy
+	}
+	fileOptions, errParse := parseFileURL(options)
+	if errParse != nil {
+		return nil, errParse
+	}
+	return filecache.NewWithOptions(fileOptions)
+}
+
+// newRedisCache creates rediscache from spec "redis:<host>:<port>:<password>:<key>"
+// or "redis://..." (see parseRedisURL).
+func newRedisCache(options Options) (token.TokenCache, error) {
+	if !isURL(options) {
+		if options.Scheme == "rediss" {
+			return nil, fmt.Errorf("%w: rediss requires URL form: rediss://...", ErrMalformedSpec)
+		}
+		// legacy
+		return rediscache.New(rediscache.Options{
+			RedisString: options.Rest,
+			TokenURL:    options.TokenURL,
+			ClientID:    options.ClientID,
+		})
+	}
+	redisOptions, errParse := parseRedisURL(options)
+	if errParse != nil {
+		return nil, errParse
+	}
+	return rediscache.New(redisOptions)
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/udhos/oauth2/token"
+)
+
+func TestRegister(t *testing.T) {
+
+	var got Options
+
+	Register("Test-Custom", func(options Options) (token.TokenCache, error) {
+		got = options
+		return token.NewMemoryCache(), nil
+	})
+	t.Cleanup(func() { unregister("test-custom") })
+
+	c, errNew := New("test-custom:some:rest", "token-url", "client-id")
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	if c == nil {
+		t.Errorf("nil cache from custom factory")
+	}
+
+	expected := Options{
+		Spec:     "test-custom:some:rest",
+		Scheme:   "test-custom",
+		Rest:     "some:rest",
+		TokenURL: "token-url",
+		ClientID: "client-id",
+	}
+	if got != expected {
+		t.Errorf("unexpected factory options: %#v", got)
+	}
+
+	schemes := Schemes()
+	for _, s := range []string{"error", "file", "redis", "test-custom"} {
+		if !slices.Contains(schemes, s) {
+			t.Errorf("scheme %s missing from: %v", s, schemes)
+		}
+	}
+	if !slices.IsSorted(schemes) {
+		t.Errorf("schemes not sorted: %v", schemes)
+	}
+}
+
+// unregister removes the scheme from the registry, so that tests
+// registering schemes can run more than once in the same process.
+func unregister(scheme string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	delete(registry, scheme)
+}
+
+func TestRegisterPanic(t *testing.T) {
+
+	factory := func(_ Options) (token.TokenCache, error) { return nil, nil }
+
+	testCases := []struct {
+		name    string
+		scheme  string
+		factory Factory
+	}{
+		{"duplicate", "file", factory},
+		{"invalid scheme", "1bad", factory},
+		{"empty scheme", "", factory},
+		{"nil factory", "nil-factory", nil},
+	}
+
+	for _, data := range testCases {
+		t.Run(data.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic")
+				}
+			}()
+			Register(data.scheme, data.factory)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		spec      string
+		expectNil bool
+		expectErr error
+	}{
+		{"empty means default", "", true, nil},
+		{"error cache", "error", false, nil},
+		{"file cache", "file:/tmp/cache", false, nil},
+		{"redis cache", "redis:localhost:6379::key", false, nil},
+		{"scheme is case-insensitive", "FILE:/tmp/cache", false, nil},
+		{"unknown scheme", "unknown:whatever", true, ErrUnknownScheme},
+		{"malformed scheme", "1bad:whatever", true, ErrMalformedSpec},
+		{"empty scheme", ":whatever", true, ErrMalformedSpec},
+	}
+
+	for _, data := range testCases {
+		t.Run(data.name, func(t *testing.T) {
+			c, errNew := New(data.spec, "token-url", "client-id")
+			if !errors.Is(errNew, data.expectErr) {
+				t.Errorf("spec=%q: expected error %v, got: %v", data.spec, data.expectErr, errNew)
+			}
+			if (c == nil) != data.expectNil {
+				t.Errorf("spec=%q: unexpected cache: %v", data.spec, c)
+			}
+		})
+	}
+}
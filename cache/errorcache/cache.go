@@ -2,6 +2,7 @@
 package errorcache
 
 import (
+	"context"
 	"errors"
 
 	"github.com/udhos/oauth2/token"
@@ -32,3 +33,33 @@ func (c *Cache) Put(_ token.Token) error {
 func (c *Cache) Expire() error {
 	return errAlways
 }
+
+// CompareAndExpire invalidates token in cache if it holds the given value.
+func (c *Cache) CompareAndExpire(_ string) (bool, error) {
+	return false, errAlways
+}
+
+// GetToken retrieves token from cache.
+func (c *Cache) GetToken(_ context.Context, _ string) (token.Token, error) {
+	return token.Token{}, errAlways
+}
+
+// PutToken inserts token into cache.
+func (c *Cache) PutToken(_ context.Context, _ string, _ token.Token) error {
+	return errAlways
+}
+
+// ExpireToken invalidates token in cache.
+func (c *Cache) ExpireToken(_ context.Context, _ string) error {
+	return errAlways
+}
+
+// DeleteToken removes token from cache.
+func (c *Cache) DeleteToken(_ context.Context, _ string) error {
+	return errAlways
+}
+
+// CompareAndExpireToken invalidates token in cache if it holds the given value.
+func (c *Cache) CompareAndExpireToken(_ context.Context, _, _ string) (bool, error) {
+	return false, errAlways
+}
@@ -2,6 +2,11 @@
 package filecache
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
 	"os"
 	"sync"
 
@@ -9,21 +14,54 @@ import (
 )
 
 // Cache holds cache client.
+//
+// The token for the default key "" is stored in the given filename.
+// Tokens for other keys are stored in separate files named after the
+// filename plus a hash of the key.
 type Cache struct {
 	filename string
+	mode     fs.FileMode
 	mutex    sync.Mutex
 }
 
+// Options define file cache options.
+type Options struct {
+	Filename string
+
+	// Mode defines permissions for the cache files.
+	// If zero, files are created as in os.Create.
+	Mode fs.FileMode
+}
+
 // New creates a new cache client.
 func New(filename string) (*Cache, error) {
-	return &Cache{filename: filename}, nil
+	return NewWithOptions(Options{Filename: filename})
+}
+
+// NewWithOptions creates a new cache client.
+func NewWithOptions(options Options) (*Cache, error) {
+	return &Cache{filename: options.Filename, mode: options.Mode}, nil
 }
 
 // Get retrieves token from cache.
 func (c *Cache) Get() (token.Token, error) {
+	return c.GetToken(context.TODO(), "")
+}
+
+// GetToken retrieves token from cache.
+func (c *Cache) GetToken(_ context.Context, key string) (token.Token, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	return tokenFromFile(c.filename)
+	return tokenFromFile(c.keyFilename(key))
+}
+
+// keyFilename gets the file name for storing the token.
+func (c *Cache) keyFilename(key string) string {
+	if key == "" {
+		return c.filename
+	}
+	sum := sha256.Sum256([]byte(key))
+	return c.filename + "." + hex.EncodeToString(sum[:8])
 }
 
 func tokenFromFile(filename string) (token.Token, error) {
@@ -36,16 +74,37 @@ func tokenFromFile(filename string) (token.Token, error) {
 
 // Put inserts token into cache.
 func (c *Cache) Put(t token.Token) error {
+	return c.PutToken(context.TODO(), "", t)
+}
+
+// PutToken inserts token into cache.
+func (c *Cache) PutToken(_ context.Context, key string, t token.Token) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	return saveToken(t, c.filename)
+	return c.saveToken(t, c.keyFilename(key))
+}
+
+func (c *Cache) saveToken(t token.Token, filename string) error {
+	if c.mode == 0 {
+		return writeToken(t, filename, 0o666)
+	}
+	// enforce mode on existing file before writing the secret into it
+	if err := os.Chmod(filename, c.mode); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := writeToken(t, filename, c.mode); err != nil {
+		return err
+	}
+	// new file was created with mode restricted by umask
+	return os.Chmod(filename, c.mode)
 }
 
-func saveToken(t token.Token, filename string) error {
-	out, errOpen := os.Create(filename)
+func writeToken(t token.Token, filename string, mode fs.FileMode) error {
+	out, errOpen := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if errOpen != nil {
 		return errOpen
 	}
+	defer out.Close()
 	buf, errJSON := t.ExportJSON()
 	if errJSON != nil {
 		return errJSON
@@ -56,12 +115,50 @@ func saveToken(t token.Token, filename string) error {
 
 // Expire invalidates token in cache.
 func (c *Cache) Expire() error {
+	return c.ExpireToken(context.TODO(), "")
+}
+
+// ExpireToken invalidates token in cache.
+func (c *Cache) ExpireToken(_ context.Context, key string) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	t, errGet := tokenFromFile(c.filename)
+	filename := c.keyFilename(key)
+	t, errGet := tokenFromFile(filename)
 	if errGet != nil {
 		return errGet
 	}
 	t.Expire()
-	return saveToken(t, c.filename)
+	return c.saveToken(t, filename)
+}
+
+// DeleteToken removes token from cache.
+func (c *Cache) DeleteToken(_ context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	err := os.Remove(c.keyFilename(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CompareAndExpire invalidates token in cache if it holds the given value.
+func (c *Cache) CompareAndExpire(value string) (bool, error) {
+	return c.CompareAndExpireToken(context.TODO(), "", value)
+}
+
+// CompareAndExpireToken invalidates token in cache if it holds the given value.
+func (c *Cache) CompareAndExpireToken(_ context.Context, key, value string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	filename := c.keyFilename(key)
+	t, errGet := tokenFromFile(filename)
+	if errGet != nil {
+		return false, errGet
+	}
+	if t.Value != value {
+		return false, nil
+	}
+	t.Expire()
+	return true, c.saveToken(t, filename)
 }
@@ -0,0 +1,151 @@
+package filecache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/udhos/oauth2/token"
+)
+
+func TestFileCache(t *testing.T) {
+
+	ctx := context.TODO()
+	now := time.Now()
+
+	c, errNew := New(filepath.Join(t.TempDir(), "token.json"))
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	if _, err := c.Get(); err == nil {
+		t.Errorf("unexpected get success from empty cache")
+	}
+
+	tk := token.Token{Value: "abc"}
+	tk.SetExpiration(now.Add(time.Minute))
+
+	if err := c.Put(tk); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	got, errGet := c.Get()
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	if got.Value != "abc" || !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("unexpected token: %v", got)
+	}
+
+	// keyed tokens are isolated from the default token
+
+	if err := c.PutToken(ctx, "other-key", token.Token{Value: "other"}); err != nil {
+		t.Fatalf("put other: %v", err)
+	}
+	if got, _ := c.GetToken(ctx, "other-key"); got.Value != "other" {
+		t.Errorf("unexpected other token: %v", got)
+	}
+	if got, _ := c.Get(); got.Value != "abc" {
+		t.Errorf("default token overwritten: %v", got)
+	}
+
+	// compare-and-expire
+
+	if expired, err := c.CompareAndExpire("old"); err != nil || expired {
+		t.Errorf("compare-and-expire old: expired=%t error=%v", expired, err)
+	}
+	if got, _ := c.Get(); !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("token expired by refusal of old token")
+	}
+	if expired, err := c.CompareAndExpire("abc"); err != nil || !expired {
+		t.Errorf("compare-and-expire current: expired=%t error=%v", expired, err)
+	}
+	if got, _ := c.Get(); got.IsValid(now, 0, t.Logf) {
+		t.Errorf("token still valid after compare-and-expire")
+	}
+	if _, err := c.CompareAndExpireToken(ctx, "missing-key", "abc"); err == nil {
+		t.Errorf("unexpected compare-and-expire success for missing key")
+	}
+
+	// delete
+
+	if err := c.DeleteToken(ctx, "other-key"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err := c.GetToken(ctx, "other-key"); err == nil {
+		t.Errorf("unexpected get success after delete")
+	}
+}
+
+func TestFileCacheCompareAndExpireConcurrentRenewal(t *testing.T) {
+
+	now := time.Now()
+
+	c, errNew := New(filepath.Join(t.TempDir(), "token.json"))
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	old := token.Token{Value: "abc"}
+	old.SetExpiration(now.Add(time.Minute))
+
+	renewed := token.Token{Value: "new"}
+	renewed.SetExpiration(now.Add(time.Minute))
+
+	for i := range 100 {
+		if err := c.Put(old); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+
+		// late refusal of the old token races with renewal
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			if _, err := c.CompareAndExpire("abc"); err != nil {
+				t.Errorf("compare-and-expire: %v", err)
+			}
+		})
+		wg.Go(func() {
+			if err := c.Put(renewed); err != nil {
+				t.Errorf("put renewed: %v", err)
+			}
+		})
+		wg.Wait()
+
+		got, errGet := c.Get()
+		if errGet != nil {
+			t.Fatalf("get: %v", errGet)
+		}
+		if got.Value != "new" || !got.IsValid(now, 0, t.Logf) {
+			t.Fatalf("iteration %d: renewed token lost: %v", i, got)
+		}
+	}
+}
+
+func TestFileCacheModeExistingFile(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "token.json")
+
+	if err := os.WriteFile(filename, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	c, errNew := NewWithOptions(Options{Filename: filename, Mode: 0o600})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	if err := c.Put(token.Token{Value: "abc"}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	info, errStat := os.Stat(filename)
+	if errStat != nil {
+		t.Fatalf("stat: %v", errStat)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("unexpected mode: %v", mode)
+	}
+}
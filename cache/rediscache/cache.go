@@ -16,7 +16,12 @@ import (
 // Cache holds cache client.
 type Cache struct {
 	key         string
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
+	ownClient   bool // client created by New, hence closed by Close
+
+	lock             bool
+	lockTTL          time.Duration
+	lockPollInterval time.Duration
 }
 
 // Options define redis options.
@@ -24,13 +29,102 @@ type Options struct {
 	// Format: RedisString = <host>:<port>:<password>:<key>
 	// Example: RedisString = localhost:6379::oauth2-client-example
 	// Leave <key> empty for auto generation.
+	// RedisString is ignored if any of Client, UniversalOptions or
+	// RedisOptions is defined.
 	RedisString string
-	TokenURL    string // only used if key is empty for auto generation
-	ClientID    string // only used if key is empty for auto generation
+
+	// Client, if defined, is used as redis client, and takes precedence
+	// over UniversalOptions and RedisOptions.
+	// Since the client is owned by the caller, Close does not close it.
+	Client redis.UniversalClient
+
+	// UniversalOptions, if defined, provides the full redis client
+	// configuration, and takes precedence over RedisOptions.
+	// Besides username, password, DB, TLS and timeouts, it supports:
+	//
+	// Sentinel: set MasterName and the sentinel addresses in Addrs.
+	//
+	// Cluster: set the cluster addresses in Addrs (two or more),
+	// or set IsClusterMode.
+	//
+	// See redis.NewUniversalClient.
+	UniversalOptions *redis.UniversalOptions
+
+	// RedisOptions, if defined, provides the full redis client
+	// configuration for a single node, like username, DB, TLS and timeouts.
+	// See redis.ParseURL for creating it from an URL.
+	RedisOptions *redis.Options
+
+	// Key is the redis key for storing the token, when any of Client,
+	// UniversalOptions or RedisOptions is defined.
+	// Leave empty for auto generation.
+	Key string
+
+	TokenURL string // only used if key is empty for auto generation
+	ClientID string // only used if key is empty for auto generation
+
+	// DistributedLock enables the distributed lock that prevents many
+	// processes sharing the cache from fetching new tokens at once.
+	// Only one process fetches the token, while the others wait for
+	// the token to appear in redis.
+	//
+	// In Cluster mode, the lock keys are derived from the token key,
+	// so use a Key with a hash tag, like "{oauth2}token", to keep
+	// them in the same slot.
+	DistributedLock bool
+
+	// LockTTL is the lock expiration, after which another process may
+	// take over if the lock holder died. It should exceed the time taken
+	// to fetch a token. 0 defaults to 10 seconds.
+	LockTTL time.Duration
+
+	// LockPollInterval is how often processes waiting for the lock check
+	// redis for the new token. 0 defaults to 100 milliseconds.
+	LockPollInterval time.Duration
 }
 
 // New creates a new cache client.
 func New(options Options) (*Cache, error) {
+	c, err := newCache(options)
+	if err != nil {
+		return nil, err
+	}
+	c.lock = options.DistributedLock
+	c.lockTTL = options.LockTTL
+	if c.lockTTL == 0 {
+		c.lockTTL = 10 * time.Second
+	}
+	c.lockPollInterval = options.LockPollInterval
+	if c.lockPollInterval == 0 {
+		c.lockPollInterval = 100 * time.Millisecond
+	}
+	return c, nil
+}
+
+func newCache(options Options) (*Cache, error) {
+	switch {
+	case options.Client != nil:
+		c := Cache{
+			redisClient: options.Client,
+			key:         autoKey(options.Key, options),
+		}
+		return &c, nil
+	case options.UniversalOptions != nil:
+		c := Cache{
+			redisClient: redis.NewUniversalClient(options.UniversalOptions),
+			key:         autoKey(options.Key, options),
+			ownClient:   true,
+		}
+		return &c, nil
+	case options.RedisOptions != nil:
+		c := Cache{
+			redisClient: redis.NewClient(options.RedisOptions),
+			key:         autoKey(options.Key, options),
+			ownClient:   true,
+		}
+		return &c, nil
+	}
+
 	fields := strings.SplitN(options.RedisString, ":", 4)
 	if len(fields) != 4 {
 		return nil, fmt.Errorf("4 fields are required, but got: %d", len(fields))
@@ -48,34 +142,59 @@ func New(options Options) (*Cache, error) {
 		port = "6379"
 	}
 
-	if key == "" {
-		key = "github.com/udhos/oauth2|" + options.TokenURL + "|" + options.ClientID + "|token"
-	}
-
 	c := Cache{
 		redisClient: redis.NewClient(&redis.Options{
 			Addr:     fmt.Sprintf("%s:%s", host, port),
 			Password: password,
 			DB:       0,
 		}),
-		key: key,
+		key:       autoKey(key, options),
+		ownClient: true,
 	}
 	return &c, nil
 }
 
+// Close releases the connection pool of the redis client created by New.
+// A client provided in Options.Client is left open.
+func (c *Cache) Close() error {
+	if !c.ownClient {
+		return nil
+	}
+	return c.redisClient.Close()
+}
+
+// autoKey generates the redis key if key is empty.
+func autoKey(key string, options Options) string {
+	if key == "" {
+		key = "github.com/udhos/oauth2|" + options.TokenURL + "|" + options.ClientID + "|token"
+	}
+	return key
+}
+
 var errRedisCacheKeyNotFound = errors.New("redis cache error: key not found")
 
 // getKey gets the redis key for storing the token.
-func (c *Cache) getKey() string {
-	return c.key
+// The token for the default key "" is stored under the configured redis key.
+// Tokens for other keys are stored under the configured redis key suffixed
+// with the key.
+func (c *Cache) getKey(key string) string {
+	if key == "" {
+		return c.key
+	}
+	return c.key + "|" + key
 }
 
 // Get retrieves token from cache.
 func (c *Cache) Get() (token.Token, error) {
+	return c.GetToken(context.TODO(), "")
+}
+
+// GetToken retrieves token from cache.
+func (c *Cache) GetToken(ctx context.Context, key string) (token.Token, error) {
 
 	var t token.Token
 
-	cmdID := c.redisClient.Get(context.TODO(), c.getKey())
+	cmdID := c.redisClient.Get(ctx, c.getKey(key))
 	errID := cmdID.Err()
 	if errID == redis.Nil {
 		return t, errRedisCacheKeyNotFound
@@ -91,32 +210,118 @@ func (c *Cache) Get() (token.Token, error) {
 
 // Put inserts token into cache.
 func (c *Cache) Put(t token.Token) error {
+	return c.PutToken(context.TODO(), "", t)
+}
 
-	buf, errJSON := t.ExportJSON()
+// PutToken inserts token into cache.
+func (c *Cache) PutToken(ctx context.Context, key string, t token.Token) error {
+
+	buf, expiration, errJSON := encode(t)
 	if errJSON != nil {
 		return errJSON
 	}
 
+	errSet := c.redisClient.Set(ctx, c.getKey(key), buf, expiration)
+
+	return errSet.Err()
+}
+
+// encode exports token as json along with its redis key expiration.
+func encode(t token.Token) ([]byte, time.Duration, error) {
+
+	buf, errJSON := t.ExportJSON()
+	if errJSON != nil {
+		return nil, 0, errJSON
+	}
+
 	var expiration time.Duration
 
 	if t.Expirable {
 		expiration = time.Until(t.Deadline) + time.Minute // token remaining TTL + 1 minute
 	}
 
-	errSet := c.redisClient.Set(context.TODO(), c.getKey(), buf, expiration)
-
-	return errSet.Err()
+	return buf, expiration, nil
 }
 
 // Expire invalidates token in cache.
 func (c *Cache) Expire() error {
+	return c.ExpireToken(context.TODO(), "")
+}
 
-	t, errGet := c.Get()
+// ExpireToken invalidates token in cache.
+func (c *Cache) ExpireToken(ctx context.Context, key string) error {
+
+	t, errGet := c.GetToken(ctx, key)
 	if errGet != nil {
 		return errGet
 	}
 
 	t.Expire()
 
-	return c.Put(t)
+	return c.PutToken(ctx, key, t)
+}
+
+// DeleteToken removes token from cache.
+func (c *Cache) DeleteToken(ctx context.Context, key string) error {
+	return c.redisClient.Del(ctx, c.getKey(key)).Err()
+}
+
+// CompareAndExpire invalidates token in cache if it holds the given value.
+func (c *Cache) CompareAndExpire(value string) (bool, error) {
+	return c.CompareAndExpireToken(context.TODO(), "", value)
+}
+
+// CompareAndExpireToken invalidates token in cache if it holds the given value.
+// The check-and-set runs under WATCH/MULTI, so a token renewed concurrently
+// by another client is never expired.
+func (c *Cache) CompareAndExpireToken(ctx context.Context, key, value string) (bool, error) {
+
+	redisKey := c.getKey(key)
+
+	var expired bool
+
+	txf := func(tx *redis.Tx) error {
+		buf, errGet := tx.Get(ctx, redisKey).Bytes()
+		if errGet == redis.Nil {
+			return errRedisCacheKeyNotFound
+		}
+		if errGet != nil {
+			return errGet
+		}
+
+		t, errJSON := token.NewTokenFromJSON(buf)
+		if errJSON != nil {
+			return errJSON
+		}
+
+		if t.Value != value {
+			return nil // token was renewed
+		}
+
+		t.Expire()
+
+		newBuf, expiration, errEncode := encode(t)
+		if errEncode != nil {
+			return errEncode
+		}
+
+		_, errExec := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisKey, newBuf, expiration)
+			return nil
+		})
+		if errExec != nil {
+			return errExec
+		}
+
+		expired = true
+		return nil
+	}
+
+	errWatch := c.redisClient.Watch(ctx, txf, redisKey)
+	if errWatch == redis.TxFailedErr {
+		// key changed concurrently, so token was renewed
+		return false, nil
+	}
+
+	return expired, errWatch
 }
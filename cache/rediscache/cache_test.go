@@ -0,0 +1,269 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udhos/oauth2/token"
+)
+
+func TestRedisCache(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	c, errNew := New(Options{
+		RedisString: mr.Host() + ":" + mr.Port() + "::my-key",
+	})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	defer c.Close()
+
+	testCache(t, c)
+
+	if !mr.Exists("my-key") {
+		t.Errorf("token not stored under redis key")
+	}
+}
+
+func TestRedisCacheACLAndDB(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	mr.RequireUserAuth("user", "pass")
+
+	c, errNew := New(Options{
+		RedisOptions: &redis.Options{
+			Addr:     mr.Addr(),
+			Username: "user",
+			Password: "pass",
+			DB:       2,
+		},
+		Key: "my-key",
+	})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	defer c.Close()
+
+	testCache(t, c)
+
+	if !mr.DB(2).Exists("my-key") {
+		t.Errorf("token not stored in DB 2")
+	}
+	if mr.DB(0).Exists("my-key") {
+		t.Errorf("token unexpectedly stored in DB 0")
+	}
+}
+
+func TestRedisCacheUniversalOptions(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	c, errNew := New(Options{
+		UniversalOptions: &redis.UniversalOptions{
+			Addrs: []string{mr.Addr()},
+		},
+		TokenURL: "token-url",
+		ClientID: "client-id",
+	})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	defer c.Close()
+
+	testCache(t, c)
+
+	if !mr.Exists("github.com/udhos/oauth2|token-url|client-id|token") {
+		t.Errorf("token not stored under auto generated key: %v", mr.Keys())
+	}
+}
+
+func TestRedisCacheClientNotClosed(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	c, errNew := New(Options{Client: client, Key: "my-key"})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if err := client.Ping(context.TODO()).Err(); err != nil {
+		t.Errorf("caller client was closed: %v", err)
+	}
+}
+
+func TestRedisCacheClose(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	c, errNew := New(Options{RedisOptions: &redis.Options{Addr: mr.Addr()}})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if err := c.Put(token.Token{Value: "abc"}); err == nil {
+		t.Errorf("unexpected put success after close")
+	}
+}
+
+// testCache exercises the cache methods.
+func testCache(t *testing.T, c *Cache) {
+	t.Helper()
+
+	ctx := context.TODO()
+	now := time.Now()
+
+	if _, err := c.Get(); err == nil {
+		t.Errorf("unexpected get success from empty cache")
+	}
+
+	tk := token.Token{Value: "abc"}
+	tk.SetExpiration(now.Add(time.Minute))
+
+	if err := c.Put(tk); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	got, errGet := c.Get()
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	if got.Value != "abc" || !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("unexpected token: %v", got)
+	}
+
+	// keyed tokens are isolated from the default token
+
+	other := token.Token{Value: "other"}
+	if err := c.PutToken(ctx, "other-key", other); err != nil {
+		t.Fatalf("put other: %v", err)
+	}
+	if got, _ := c.GetToken(ctx, "other-key"); got.Value != "other" {
+		t.Errorf("unexpected other token: %v", got)
+	}
+	if got, _ := c.Get(); got.Value != "abc" {
+		t.Errorf("default token overwritten: %v", got)
+	}
+
+	// compare-and-expire
+
+	if expired, err := c.CompareAndExpire("old"); err != nil || expired {
+		t.Errorf("compare-and-expire old: expired=%t error=%v", expired, err)
+	}
+	if got, _ := c.Get(); !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("token expired by refusal of old token")
+	}
+	if expired, err := c.CompareAndExpire("abc"); err != nil || !expired {
+		t.Errorf("compare-and-expire current: expired=%t error=%v", expired, err)
+	}
+	if got, _ := c.Get(); got.IsValid(now, 0, t.Logf) {
+		t.Errorf("token still valid after compare-and-expire")
+	}
+
+	// delete
+
+	if err := c.DeleteToken(ctx, "other-key"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err := c.GetToken(ctx, "other-key"); err == nil {
+		t.Errorf("unexpected get success after delete")
+	}
+}
+
+func TestRedisCacheCompareAndExpireConcurrentRenewal(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	now := time.Now()
+
+	// another process renews the token between WATCH and MULTI/EXEC
+
+	other, errOther := New(Options{RedisString: mr.Host() + ":" + mr.Port() + "::my-key"})
+	if errOther != nil {
+		t.Fatalf("new other: %v", errOther)
+	}
+	defer other.Close()
+
+	renewed := token.Token{Value: "new"}
+	renewed.SetExpiration(now.Add(time.Minute))
+
+	hook := &afterGetHook{f: func() {
+		if err := other.Put(renewed); err != nil {
+			t.Errorf("renew: %v", err)
+		}
+	}}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(hook)
+
+	c, errNew := New(Options{Client: client, Key: "my-key"})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	old := token.Token{Value: "abc"}
+	old.SetExpiration(now.Add(time.Minute))
+	if err := other.Put(old); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	expired, errExpire := c.CompareAndExpire("abc")
+	if errExpire != nil {
+		t.Fatalf("compare-and-expire: %v", errExpire)
+	}
+	if expired {
+		t.Errorf("renewed token must not be expired")
+	}
+	if !hook.called {
+		t.Errorf("renewal hook not called")
+	}
+
+	got, errGet := c.Get()
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	if got.Value != "new" || !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("unexpected token after concurrent renewal: %v", got)
+	}
+}
+
+// afterGetHook calls f once, right after the first GET command.
+type afterGetHook struct {
+	f      func()
+	called bool
+}
+
+func (h *afterGetHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *afterGetHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "get" && !h.called {
+			h.called = true
+			h.f()
+		}
+		return err
+	}
+}
+
+func (h *afterGetHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
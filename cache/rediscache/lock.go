@@ -0,0 +1,125 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// unlockScript deletes the lock only if it is still held with the fence.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// putScript stores the token only if the lock is still held with the fence.
+// ARGV[3] is the token key expiration in milliseconds, or 0 for none.
+var putScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// lockKey gets the redis key for the lock guarding the token.
+func (c *Cache) lockKey(key string) string {
+	return c.getKey(key) + "|lock"
+}
+
+// fenceKey gets the redis key for the fencing token counter.
+func (c *Cache) fenceKey(key string) string {
+	return c.getKey(key) + "|fence"
+}
+
+// TryLockToken attempts to acquire the distributed lock for fetching the
+// token under key, with SET NX PX. The lock value is the fencing token,
+// taken from an INCR counter.
+// It returns nil lock if the lock is held by another process.
+// It returns token.ErrLockUnsupported unless option DistributedLock is enabled.
+func (c *Cache) TryLockToken(ctx context.Context, key string) (token.TokenLock, error) {
+	if !c.lock {
+		return nil, token.ErrLockUnsupported
+	}
+
+	fence, errIncr := c.redisClient.Incr(ctx, c.fenceKey(key)).Result()
+	if errIncr != nil {
+		return nil, errIncr
+	}
+
+	acquired, errSet := c.redisClient.SetNX(ctx, c.lockKey(key), fence, c.lockTTL).Result()
+	if errSet != nil {
+		return nil, errSet
+	}
+	if !acquired {
+		return nil, nil
+	}
+
+	return &redisLock{cache: c, key: key, fence: fence}, nil
+}
+
+// LockPollInterval is how long to wait before checking the cache again
+// while the lock is held by another process.
+func (c *Cache) LockPollInterval() time.Duration {
+	return c.lockPollInterval
+}
+
+// redisLock implements token.TokenLock.
+type redisLock struct {
+	cache *Cache
+	key   string
+	fence int64
+}
+
+// Fence returns the fencing token.
+func (l *redisLock) Fence() int64 {
+	return l.fence
+}
+
+// PutToken inserts token into cache only if the lock is still held.
+func (l *redisLock) PutToken(ctx context.Context, t token.Token) error {
+
+	buf, expiration, errJSON := encode(t)
+	if errJSON != nil {
+		return errJSON
+	}
+
+	var ms int64
+	if expiration > 0 {
+		ms = max(expiration.Milliseconds(), 1)
+	}
+
+	keys := []string{l.cache.lockKey(l.key), l.cache.getKey(l.key)}
+
+	stored, errRun := putScript.Run(ctx, l.cache.redisClient, keys,
+		strconv.FormatInt(l.fence, 10), buf, ms).Int()
+	if errRun != nil {
+		return errRun
+	}
+	if stored == 0 {
+		return token.ErrLockLost
+	}
+
+	return nil
+}
+
+// Unlock releases the lock, if still held.
+func (l *redisLock) Unlock(ctx context.Context) error {
+	errRun := unlockScript.Run(ctx, l.cache.redisClient,
+		[]string{l.cache.lockKey(l.key)}, strconv.FormatInt(l.fence, 10)).Err()
+	if errors.Is(errRun, redis.Nil) {
+		return nil
+	}
+	return errRun
+}
@@ -0,0 +1,122 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/udhos/oauth2/token"
+)
+
+func TestLockDisabled(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	c, errNew := New(Options{RedisString: mr.Addr() + "::my-key"})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	defer c.Close()
+
+	if _, err := c.TryLockToken(context.TODO(), ""); !errors.Is(err, token.ErrLockUnsupported) {
+		t.Errorf("expected ErrLockUnsupported, got: %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	ttl := 5 * time.Second
+
+	c, errNew := New(Options{
+		RedisString:     mr.Addr() + "::my-key",
+		DistributedLock: true,
+		LockTTL:         ttl,
+	})
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+	defer c.Close()
+
+	ctx := context.TODO()
+
+	// first process acquires the lock
+
+	lock1, errLock1 := c.TryLockToken(ctx, "")
+	if errLock1 != nil || lock1 == nil {
+		t.Fatalf("lock 1: lock=%v error=%v", lock1, errLock1)
+	}
+
+	// second process finds the lock busy
+
+	busy, errBusy := c.TryLockToken(ctx, "")
+	if errBusy != nil || busy != nil {
+		t.Fatalf("busy: lock=%v error=%v", busy, errBusy)
+	}
+
+	// other keys have their own locks
+
+	other, errOther := c.TryLockToken(ctx, "other-key")
+	if errOther != nil || other == nil {
+		t.Fatalf("other key: lock=%v error=%v", other, errOther)
+	}
+
+	// holder stores token
+
+	tk := token.Token{Value: "abc"}
+	tk.SetExpiration(time.Now().Add(time.Minute))
+
+	if err := lock1.PutToken(ctx, tk); err != nil {
+		t.Errorf("fenced put: %v", err)
+	}
+	if got, _ := c.Get(); got.Value != "abc" {
+		t.Errorf("unexpected token: %v", got)
+	}
+	if ttl := mr.TTL("my-key"); ttl <= 0 {
+		t.Errorf("missing token key expiration: %v", ttl)
+	}
+
+	// holder dies: lock expires and second process takes over
+
+	mr.FastForward(ttl)
+
+	lock2, errLock2 := c.TryLockToken(ctx, "")
+	if errLock2 != nil || lock2 == nil {
+		t.Fatalf("lock 2: lock=%v error=%v", lock2, errLock2)
+	}
+	if lock2.Fence() <= lock1.Fence() {
+		t.Errorf("fence did not increase: %d <= %d", lock2.Fence(), lock1.Fence())
+	}
+
+	// stale holder can neither store token nor release new holder's lock
+
+	stale := token.Token{Value: "stale"}
+	if err := lock1.PutToken(ctx, stale); !errors.Is(err, token.ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got: %v", err)
+	}
+	if got, _ := c.Get(); got.Value != "abc" {
+		t.Errorf("stale holder overwrote token: %v", got)
+	}
+
+	if err := lock1.Unlock(ctx); err != nil {
+		t.Errorf("stale unlock: %v", err)
+	}
+	if busy, _ := c.TryLockToken(ctx, ""); busy != nil {
+		t.Errorf("stale holder released new holder's lock")
+	}
+
+	// new holder releases lock
+
+	if err := lock2.Unlock(ctx); err != nil {
+		t.Errorf("unlock: %v", err)
+	}
+
+	lock3, errLock3 := c.TryLockToken(ctx, "")
+	if errLock3 != nil || lock3 == nil {
+		t.Fatalf("lock 3 after unlock: lock=%v error=%v", lock3, errLock3)
+	}
+}
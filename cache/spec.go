@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udhos/oauth2/cache/filecache"
+	"github.com/udhos/oauth2/cache/rediscache"
+)
+
+// isURL checks whether the spec is in RFC 3986 URL form "<scheme>://...",
+// as opposed to the legacy form "<scheme>:...".
+func isURL(options Options) bool {
+	return strings.HasPrefix(options.Rest, "//")
+}
+
+// parseRedisURL parses redis spec in URL form:
+//
+//	redis://[[user]:password@]host[:port][/db][?option=value&...]
+//	rediss://[[user]:password@]host[:port][/db][?option=value&...]
+//
+// Besides the options supported by redis.ParseURL, like dial_timeout,
+// read_timeout, write_timeout and skip_verify, these options are accepted:
+//
+//	key=<redis key>               redis key for the token, leave empty for auto generation.
+//	tls=true                      enable TLS, same as scheme rediss.
+//	lock=true                     enable distributed lock.
+//	lock_ttl=<duration>           distributed lock expiration.
+//	lock_poll_interval=<duration> distributed lock poll interval.
+func parseRedisURL(options Options) (rediscache.Options, error) {
+	var result rediscache.Options
+
+	u, errParse := url.Parse(options.Spec)
+	if errParse != nil {
+		return result, fmt.Errorf("%w: %v", ErrMalformedSpec, errParse)
+	}
+
+	q := u.Query()
+
+	result.Key = q.Get("key")
+	q.Del("key")
+
+	if tlsStr := q.Get("tls"); tlsStr != "" {
+		enable, errBool := strconv.ParseBool(tlsStr)
+		if errBool != nil {
+			return result, fmt.Errorf("%w: tls: %v", ErrMalformedSpec, errBool)
+		}
+		if enable {
+			u.Scheme = "rediss"
+		}
+	}
+	q.Del("tls")
+
+	if lockStr := q.Get("lock"); lockStr != "" {
+		enable, errBool := strconv.ParseBool(lockStr)
+		if errBool != nil {
+			return result, fmt.Errorf("%w: lock: %v", ErrMalformedSpec, errBool)
+		}
+		result.DistributedLock = enable
+	}
+	q.Del("lock")
+
+	for _, d := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"lock_ttl", &result.LockTTL},
+		{"lock_poll_interval", &result.LockPollInterval},
+	} {
+		if str := q.Get(d.name); str != "" {
+			dur, errDur := time.ParseDuration(str)
+			if errDur != nil {
+				return result, fmt.Errorf("%w: %s: %v", ErrMalformedSpec, d.name, errDur)
+			}
+			*d.value = dur
+		}
+		q.Del(d.name)
+	}
+
+	u.RawQuery = q.Encode()
+
+	redisOptions, errRedis := redis.ParseURL(u.String())
+	if errRedis != nil {
+		return result, fmt.Errorf("%w: %v", ErrMalformedSpec, errRedis)
+	}
+
+	result.RedisOptions = redisOptions
+	result.TokenURL = options.TokenURL
+	result.ClientID = options.ClientID
+
+	return result, nil
+}
+
+// parseFileURL parses file spec in URL form:
+//
+//	file:///path/to/file[?mode=0600]
+//
+// mode is the octal permission for the cache files.
+func parseFileURL(options Options) (filecache.Options, error) {
+	var result filecache.Options
+
+	u, errParse := url.Parse(options.Spec)
+	if errParse != nil {
+		return result, fmt.Errorf("%w: %v", ErrMalformedSpec, errParse)
+	}
+
+	if u.Host != "" && u.Host != "localhost" {
+		return result, fmt.Errorf("%w: non-local file host: %s", ErrMalformedSpec, u.Host)
+	}
+
+	if u.Path == "" {
+		return result, fmt.Errorf("%w: missing file path", ErrMalformedSpec)
+	}
+
+	result.Filename = u.Path
+
+	for k, v := range u.Query() {
+		switch k {
+		case "mode":
+			mode, errMode := strconv.ParseUint(v[0], 8, 32)
+			if errMode != nil || mode > 0o777 {
+				return result, fmt.Errorf("%w: invalid octal file mode: %s", ErrMalformedSpec, v[0])
+			}
+			result.Mode = fs.FileMode(mode)
+		default:
+			return result, fmt.Errorf("%w: unexpected file option: %s", ErrMalformedSpec, k)
+		}
+	}
+
+	return result, nil
+}
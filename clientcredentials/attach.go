@@ -0,0 +1,75 @@
+package clientcredentials
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// ErrTokenTypeMismatch is returned when the token server returns a
+// token_type other than option ExpectedTokenType.
+var ErrTokenTypeMismatch = errors.New("token type mismatch")
+
+// checkTokenType checks the returned token_type against the expected one.
+func checkTokenType(expected, returned string) error {
+	if expected == "" {
+		return nil
+	}
+	if returned == "" {
+		returned = "Bearer"
+	}
+	if !strings.EqualFold(expected, returned) {
+		return fmt.Errorf("%w: expected=%s returned=%s", ErrTokenTypeMismatch, expected, returned)
+	}
+	return nil
+}
+
+// TokenScheme gets the Authorization scheme for the token type.
+// Empty type and any case of "bearer" give "Bearer", as recommended
+// by RFC 6750. Other types are used as is.
+func TokenScheme(tokenType string) string {
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		return "Bearer"
+	}
+	return tokenType
+}
+
+// DefaultAttachToken is used as default function when option AttachToken
+// is left undefined. It sets the header "Authorization: <scheme> <token>",
+// with the scheme from the token type. See TokenScheme.
+func DefaultAttachToken(req *http.Request, t token.Token) {
+	req.Header.Set("Authorization", TokenScheme(t.Type)+" "+t.Value)
+}
+
+// AttachHeader creates a function for option AttachToken that sets the
+// token in a custom header, like "X-Api-Token", prefixed with scheme.
+// Empty scheme sends the bare token.
+//
+// Example:
+//
+//	options.AttachToken = clientcredentials.AttachHeader("X-Api-Token", "")
+func AttachHeader(name, scheme string) func(req *http.Request, t token.Token) {
+	return func(req *http.Request, t token.Token) {
+		value := t.Value
+		if scheme != "" {
+			value = scheme + " " + value
+		}
+		req.Header.Set(name, value)
+	}
+}
+
+// AttachQuery creates a function for option AttachToken that sets the
+// token in the URL query parameter name, like "access_token".
+//
+// Notice RFC 6750 discourages sending the token in the URL, since
+// URLs are often logged.
+func AttachQuery(name string) func(req *http.Request, t token.Token) {
+	return func(req *http.Request, t token.Token) {
+		q := req.URL.Query()
+		q.Set(name, t.Value)
+		req.URL.RawQuery = q.Encode()
+	}
+}
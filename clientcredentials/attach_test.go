@@ -0,0 +1,140 @@
+package clientcredentials
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// go test -run TestAttachToken -count 1 ./clientcredentials
+func TestAttachToken(t *testing.T) {
+
+	testCases := []struct {
+		name        string
+		tokenType   string
+		attach      func(req *http.Request, t token.Token)
+		header      string
+		expected    string
+		expectQuery bool
+	}{
+		{"default bearer", "bearer", nil, "Authorization", "Bearer abc", false},
+		{"default missing type", "", nil, "Authorization", "Bearer abc", false},
+		{"default other type", "mac", nil, "Authorization", "mac abc", false},
+		{"custom header", "Bearer", AttachHeader("X-Api-Token", ""), "X-Api-Token", "abc", false},
+		{"custom header scheme", "Bearer", AttachHeader("X-Api-Token", "Token"), "X-Api-Token", "Token abc", false},
+		{"query", "Bearer", AttachQuery("access_token"), "", "abc", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			ts := newTokenServerType("abc", tc.tokenType)
+			defer ts.Close()
+
+			var mutex sync.Mutex
+			var got *http.Request
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				got = r
+				mutex.Unlock()
+				httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+			}))
+			defer srv.Close()
+
+			client := New(Options{
+				TokenURL:     ts.URL,
+				ClientID:     "clientID",
+				ClientSecret: "clientSecret",
+				AttachToken:  tc.attach,
+			})
+
+			if _, errSend := send(client, srv.URL+"?a=b"); errSend != nil {
+				t.Fatalf("send: %v", errSend)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if tc.expectQuery {
+				if v := got.URL.Query().Get("access_token"); v != tc.expected {
+					t.Errorf("query: expected=%q got=%q", tc.expected, v)
+				}
+				if v := got.URL.Query().Get("a"); v != "b" {
+					t.Errorf("query: original parameter lost: %q", v)
+				}
+				if v := got.Header.Get("Authorization"); v != "" {
+					t.Errorf("unexpected Authorization header: %q", v)
+				}
+				return
+			}
+
+			if v := got.Header.Get(tc.header); v != tc.expected {
+				t.Errorf("header %s: expected=%q got=%q", tc.header, tc.expected, v)
+			}
+		})
+	}
+}
+
+// go test -run TestTokenTypeMismatch -count 1 ./clientcredentials
+func TestTokenTypeMismatch(t *testing.T) {
+
+	testCases := []struct {
+		expected  string
+		returned  string
+		expectErr bool
+	}{
+		{"Bearer", "Bearer", false},
+		{"Bearer", "bearer", false},
+		{"Bearer", "", false},
+		{"Bearer", "DPoP", true},
+		{"DPoP", "", true},
+		{"", "mac", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.expected, tc.returned), func(t *testing.T) {
+
+			ts := newTokenServerType("abc", tc.returned)
+			defer ts.Close()
+
+			serverStat := serverStat{}
+			srv := newServer(&serverStat, func(string) bool { return true })
+			defer srv.Close()
+
+			client := New(Options{
+				TokenURL:          ts.URL,
+				ClientID:          "clientID",
+				ClientSecret:      "clientSecret",
+				ExpectedTokenType: tc.expected,
+			})
+
+			_, errSend := send(client, srv.URL)
+			if tc.expectErr {
+				if !errors.Is(errSend, ErrTokenTypeMismatch) {
+					t.Errorf("expected token type mismatch, got: %v", errSend)
+				}
+				return
+			}
+			if errSend != nil {
+				t.Errorf("send: %v", errSend)
+			}
+		})
+	}
+}
+
+// newTokenServerType creates a token server that returns token_type.
+func newTokenServerType(token, tokenType string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if tokenType == "" {
+			httpJSON(w, fmt.Sprintf(`{"access_token":"%s"}`, token), http.StatusOK)
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s","token_type":"%s"}`, token, tokenType), http.StatusOK)
+	}))
+}
@@ -0,0 +1,115 @@
+package clientcredentials
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// AuthMethod defines how the client authenticates to the token server.
+// The names follow the OAuth token endpoint authentication methods
+// registered by RFC 7591.
+type AuthMethod string
+
+// Client authentication methods.
+const (
+	// AuthMethodPost sends client_id and client_secret as form fields
+	// in the request body.
+	AuthMethodPost AuthMethod = "client_secret_post"
+
+	// AuthMethodBasic sends client_id and client_secret with HTTP Basic
+	// authentication, both form-urlencoded as required by RFC 6749
+	// section 2.3.1.
+	AuthMethodBasic AuthMethod = "client_secret_basic"
+
+	// AuthMethodNone sends only client_id as form field, for public clients.
+	AuthMethodNone AuthMethod = "none"
+
+	// AuthMethodPrivateKeyJWT sends a client assertion, a JWT signed with
+	// option PrivateKey or PrivateKeyPEM, as defined by RFC 7523.
+	// A fresh assertion is created for every token request.
+	AuthMethodPrivateKeyJWT AuthMethod = "private_key_jwt"
+
+	// AuthMethodClientSecretJWT sends a client assertion, a JWT signed
+	// with HMAC keyed by ClientSecret, as defined by RFC 7523, so that the
+	// secret itself is never sent. A fresh assertion is created for every
+	// token request.
+	AuthMethodClientSecretJWT AuthMethod = "client_secret_jwt"
+
+	// AuthMethodTLSClientAuth authenticates the client with mutual TLS,
+	// using a certificate issued by a trusted CA (RFC 8705 section 2.1).
+	// Only client_id is sent as form field, never client_secret.
+	// Requires option ClientCertificate or GetClientCertificate.
+	AuthMethodTLSClientAuth AuthMethod = "tls_client_auth"
+
+	// AuthMethodSelfSignedTLSClientAuth authenticates the client with
+	// mutual TLS, using a self-signed certificate registered with the
+	// token server (RFC 8705 section 2.2).
+	// Only client_id is sent as form field, never client_secret.
+	// Requires option ClientCertificate or GetClientCertificate.
+	AuthMethodSelfSignedTLSClientAuth AuthMethod = "self_signed_tls_client_auth"
+
+	// AuthMethodAuto tries AuthMethodBasic, falling back to AuthMethodPost
+	// when the token server refuses the client with invalid_client or
+	// status 401. The method that succeeds is used from then on.
+	AuthMethodAuto AuthMethod = "auto"
+)
+
+// sendTokenRequest sends the client credentials token request,
+// authenticating the client as defined by option AuthMethod.
+// It also returns the token server response, if any, in order to classify
+// the failure. The response body is already consumed and closed.
+func (c *Client) sendTokenRequest(ctx context.Context) (*TokenResponse, *http.Response, error) {
+
+	method := c.options.AuthMethod
+
+	if method != AuthMethodAuto {
+		return c.sendTokenRequestWith(ctx, method)
+	}
+
+	if detected := c.detectedAuthMethod.Load(); detected != nil {
+		return c.sendTokenRequestWith(ctx, *detected)
+	}
+
+	tr, resp, err := c.sendTokenRequestWith(ctx, AuthMethodBasic)
+	if err == nil {
+		c.detectAuthMethod(AuthMethodBasic)
+		return tr, resp, nil
+	}
+	if !isClientRefused(resp, err) {
+		return tr, resp, err
+	}
+
+	c.debugf("auth method auto: %s refused: %v", AuthMethodBasic, err)
+
+	tr, resp, err = c.sendTokenRequestWith(ctx, AuthMethodPost)
+	if err == nil {
+		c.detectAuthMethod(AuthMethodPost)
+	}
+	return tr, resp, err
+}
+
+// detectAuthMethod remembers the method found by AuthMethodAuto.
+func (c *Client) detectAuthMethod(method AuthMethod) {
+	c.debugf("auth method auto: detected %s", method)
+	c.detectedAuthMethod.Store(&method)
+}
+
+// isClientRefused checks whether the token server refused the client
+// authentication.
+func isClientRefused(resp *http.Response, err error) bool {
+	if errors.Is(err, ErrInvalidClient) {
+		return true
+	}
+	return resp != nil && resp.StatusCode == http.StatusUnauthorized
+}
+
+// basicAuth encodes the Authorization header for client_secret_basic.
+// Unlike http.Request.SetBasicAuth, the client id and secret are
+// form-urlencoded before base64 encoding, as required by RFC 6749.
+func basicAuth(clientID, clientSecret string) string {
+	credentials := url.QueryEscape(clientID) + ":" + url.QueryEscape(clientSecret)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
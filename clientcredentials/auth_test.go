@@ -0,0 +1,155 @@
+package clientcredentials
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// go test -run TestAuthMethod -count 1 ./clientcredentials
+func TestAuthMethod(t *testing.T) {
+
+	const (
+		clientID     = "client id:é"
+		clientSecret = "secret&+/:"
+	)
+
+	testCases := []struct {
+		name        string
+		method      AuthMethod
+		accept      AuthMethod
+		expectError bool
+		attempts    int
+	}{
+		{"default post", "", AuthMethodPost, false, 1},
+		{"post", AuthMethodPost, AuthMethodPost, false, 1},
+		{"post refused", AuthMethodPost, AuthMethodBasic, true, 1},
+		{"basic", AuthMethodBasic, AuthMethodBasic, false, 1},
+		{"basic refused", AuthMethodBasic, AuthMethodPost, true, 1},
+		{"none", AuthMethodNone, AuthMethodNone, false, 1},
+		{"auto basic", AuthMethodAuto, AuthMethodBasic, false, 1},
+		{"auto post", AuthMethodAuto, AuthMethodPost, false, 2},
+		{"auto none", AuthMethodAuto, AuthMethodNone, true, 2},
+		{"unsupported", "bogus", AuthMethodPost, true, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			tokenServerStat := serverStat{}
+
+			ts := newTokenServerAuth(&tokenServerStat, clientID, clientSecret, tc.accept)
+			defer ts.Close()
+
+			serverStat := serverStat{}
+			srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+			defer srv.Close()
+
+			client := New(Options{
+				TokenURL:     ts.URL,
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				AuthMethod:   tc.method,
+			})
+
+			_, errSend := send(client, srv.URL)
+			if tc.expectError {
+				if errSend == nil {
+					t.Errorf("expected error")
+				}
+			} else if errSend != nil {
+				t.Errorf("send: %v", errSend)
+			}
+
+			if tokenServerStat.count != tc.attempts {
+				t.Errorf("unexpected token server access count: expected=%d got=%d",
+					tc.attempts, tokenServerStat.count)
+			}
+		})
+	}
+}
+
+// go test -run TestAuthMethodAutoRemembers -count 1 ./clientcredentials
+func TestAuthMethodAutoRemembers(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServerAuth(&tokenServerStat, "clientID", "clientSecret", AuthMethodPost)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		AuthMethod:   AuthMethodAuto,
+	})
+
+	for range 2 {
+		if _, err := client.fetchToken(t.Context()); err != nil {
+			t.Fatalf("fetch token: %v", err)
+		}
+	}
+
+	// first fetch: basic (refused) + post, second fetch: post
+
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	// RFC 6749 2.3.1: id and secret are form-urlencoded before base64
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", basicAuth("a b:c", "d+e"))
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		t.Fatalf("bad basic auth header")
+	}
+	if user != "a+b%3Ac" || pass != "d%2Be" {
+		t.Errorf("unexpected encoding: user=%q pass=%q", user, pass)
+	}
+}
+
+// newTokenServerAuth creates a token server that accepts only the client
+// authentication method accept. RFC 6749 form-urlencoding of the basic
+// credentials is decoded.
+func newTokenServerAuth(serverInfo *serverStat, clientID, clientSecret string, accept AuthMethod) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		serverInfo.inc()
+
+		r.ParseForm()
+
+		var method AuthMethod
+		var id, secret string
+
+		if user, pass, ok := r.BasicAuth(); ok {
+			method = AuthMethodBasic
+			id = unescape(user)
+			secret = unescape(pass)
+		} else if formParam(r, "client_secret") != "" {
+			method = AuthMethodPost
+			id = formParam(r, "client_id")
+			secret = formParam(r, "client_secret")
+		} else {
+			method = AuthMethodNone
+			id = formParam(r, "client_id")
+		}
+
+		if method != accept || id != clientID || (method != AuthMethodNone && secret != clientSecret) {
+			httpJSON(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		httpJSON(w, `{"access_token":"abc"}`, http.StatusOK)
+	}))
+}
+
+func unescape(s string) string {
+	u, err := url.QueryUnescape(s)
+	if err != nil {
+		return "<bad-encoding>"
+	}
+	return u
+}
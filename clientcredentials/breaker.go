@@ -0,0 +1,217 @@
+package clientcredentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the token fetch circuit breaker.
+type CircuitState int
+
+// Circuit breaker states.
+const (
+	CircuitClosed   CircuitState = iota // token fetches flow normally
+	CircuitOpen                         // token fetches fail fast
+	CircuitHalfOpen                     // a single probe fetch is in flight
+)
+
+// String returns the state name.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// ErrCircuitOpen matches, with errors.Is, the error returned by token
+// fetches refused by the open circuit breaker.
+var ErrCircuitOpen = errors.New("token fetch circuit breaker is open")
+
+// CircuitOpenError is returned by token fetches refused by the open
+// circuit breaker. It wraps the failure that last opened the breaker.
+type CircuitOpenError struct {
+	// Until is when the breaker turns half-open.
+	// It is zero while the half-open probe is in flight.
+	Until time.Time
+
+	// Err is the failure that last opened the breaker.
+	Err error
+}
+
+// Error implements error.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v: until=%v: last error: %v", ErrCircuitOpen, e.Until, e.Err)
+}
+
+// Unwrap returns the failure that last opened the breaker.
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// circuitBreaker holds the circuit breaker and negative cache state.
+// The breaker tracks the token server health, shared by all tokens,
+// while the negative cache holds the last failure per token key.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	state    CircuitState
+	failures int                      // consecutive failures
+	openedAt time.Time                // when the breaker last opened
+	lastErr  error                    // last token server failure
+	negative map[string]negativeEntry // last fetch failure by token key
+}
+
+// negativeEntry is a fetch failure held by the negative cache.
+type negativeEntry struct {
+	err error
+	at  time.Time
+}
+
+// transition records a state change to be reported after unlock.
+type transition struct {
+	from, to CircuitState
+}
+
+// allowFetch checks whether a token fetch for key may reach the token server.
+func (c *Client) allowFetch(key string) error {
+	b := &c.breaker
+	now := c.options.TimeSource()
+
+	b.mutex.Lock()
+
+	if e, found := b.negative[key]; found {
+		if now.Before(e.at.Add(c.options.NegativeCacheTTL)) {
+			b.mutex.Unlock()
+			return fmt.Errorf("negative cache: %w", e.err)
+		}
+		delete(b.negative, key)
+	}
+
+	if c.options.CircuitBreakerThreshold < 1 {
+		b.mutex.Unlock()
+		return nil
+	}
+
+	var changes []transition
+	var err error
+
+	switch b.state {
+	case CircuitOpen:
+		until := b.openedAt.Add(c.options.CircuitBreakerOpenTimeout)
+		if now.Before(until) {
+			err = &CircuitOpenError{Until: until, Err: b.lastErr}
+			break
+		}
+		// this caller is the probe
+		changes = append(changes, b.setState(CircuitHalfOpen))
+	case CircuitHalfOpen:
+		err = &CircuitOpenError{Err: b.lastErr} // probe in flight
+	}
+
+	b.mutex.Unlock()
+
+	c.reportTransitions(changes)
+
+	return err
+}
+
+// recordFetch updates the circuit breaker and negative cache with the
+// outcome of a token fetch for key allowed by allowFetch.
+func (c *Client) recordFetch(ctx context.Context, key string, errFetch error) {
+	b := &c.breaker
+	now := c.options.TimeSource()
+
+	b.mutex.Lock()
+
+	if errFetch == nil {
+		delete(b.negative, key)
+	} else if ctx.Err() == nil && c.options.NegativeCacheTTL > 0 {
+		if b.negative == nil {
+			b.negative = map[string]negativeEntry{}
+		}
+		b.negative[key] = negativeEntry{err: errFetch, at: now}
+	}
+
+	var changes []transition
+
+	switch {
+	case errFetch == nil, isRequestError(errFetch):
+		// the token server is healthy, even if it refused this request
+		b.failures = 0
+		b.lastErr = nil
+		if b.state != CircuitClosed {
+			changes = append(changes, b.setState(CircuitClosed))
+		}
+	case ctx.Err() != nil:
+		// caller gave up: this tells nothing about the token server
+		if b.state == CircuitHalfOpen {
+			// let another caller probe
+			changes = append(changes, b.setState(CircuitOpen))
+			b.openedAt = now.Add(-c.options.CircuitBreakerOpenTimeout)
+		}
+	default:
+		b.failures++
+		b.lastErr = errFetch
+		threshold := c.options.CircuitBreakerThreshold
+		if threshold > 0 && (b.state == CircuitHalfOpen || b.failures >= threshold) {
+			if b.state != CircuitOpen {
+				changes = append(changes, b.setState(CircuitOpen))
+			}
+			b.openedAt = now
+		}
+	}
+
+	b.mutex.Unlock()
+
+	c.reportTransitions(changes)
+}
+
+// forgetNegative drops the negative cache entry for key.
+func (b *circuitBreaker) forgetNegative(key string) {
+	b.mutex.Lock()
+	delete(b.negative, key)
+	b.mutex.Unlock()
+}
+
+// isRequestError checks whether the token server refused the token
+// request itself, like a bad scope, rather than failed.
+func isRequestError(err error) bool {
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) {
+		return false
+	}
+	switch tokenErr.Code {
+	case ErrInvalidRequest.Error(), ErrInvalidScope.Error(), "invalid_target":
+		return true
+	}
+	return false
+}
+
+// setState changes the state, returning the transition.
+func (b *circuitBreaker) setState(state CircuitState) transition {
+	t := transition{from: b.state, to: state}
+	b.state = state
+	return t
+}
+
+// reportTransitions logs the state changes and calls the hook.
+func (c *Client) reportTransitions(changes []transition) {
+	for _, t := range changes {
+		c.debugf("circuit breaker: %v -> %v", t.from, t.to)
+		if c.options.OnCircuitBreakerStateChange != nil {
+			c.options.OnCircuitBreakerStateChange(t.from, t.to)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package clientcredentials
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// go test -run TestCircuitBreaker -count 1 ./clientcredentials
+func TestCircuitBreaker(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+	down.Store(true)
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 0)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return strings.HasPrefix(t, "token-") })
+	defer srv.Close()
+
+	var changes []string
+
+	client := New(Options{
+		TokenURL:                  ts.URL,
+		ClientID:                  "clientID",
+		ClientSecret:              "clientSecret",
+		TimeSource:                clock.Now,
+		DisableSingleFlight:       true,
+		CircuitBreakerThreshold:   3,
+		CircuitBreakerOpenTimeout: 30 * time.Second,
+		OnCircuitBreakerStateChange: func(from, to CircuitState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+
+	// 3 consecutive failures open the breaker
+
+	for range 3 {
+		if _, errSend := send(client, srv.URL); errSend == nil {
+			t.Errorf("expected error")
+		}
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// open breaker fails fast
+
+	_, errSend := send(client, srv.URL)
+	if !errors.Is(errSend, ErrCircuitOpen) {
+		t.Errorf("expected circuit open error, got: %v", errSend)
+	}
+	var errOpen *CircuitOpenError
+	if !errors.As(errSend, &errOpen) {
+		t.Errorf("expected CircuitOpenError, got: %T", errSend)
+	} else if errOpen.Err == nil {
+		t.Errorf("CircuitOpenError should wrap the last failure")
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("open breaker hit token server: access count: %d", tokenServerStat.count)
+	}
+
+	// half-open probe fails: breaker opens again
+
+	clock.Advance(30 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend == nil || errors.Is(errSend, ErrCircuitOpen) {
+		t.Errorf("expected probe failure, got: %v", errSend)
+	}
+	if tokenServerStat.count != 4 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if _, errSend := send(client, srv.URL); !errors.Is(errSend, ErrCircuitOpen) {
+		t.Errorf("expected circuit open error, got: %v", errSend)
+	}
+
+	// half-open probe succeeds: breaker closes
+
+	down.Store(false)
+	clock.Advance(30 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 5 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	expected := []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("unexpected state changes: expected=%v got=%v", expected, changes)
+	}
+}
+
+// go test -run TestCircuitBreakerSingleProbe -count 1 ./clientcredentials
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+
+	clock := newFakeClock()
+
+	client := New(Options{
+		TokenURL:                "http://localhost/token",
+		TimeSource:              clock.Now,
+		CircuitBreakerThreshold: 1,
+	})
+
+	client.recordFetch(context.TODO(), "", errors.New("token server down"))
+
+	if err := client.allowFetch(""); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected circuit open error, got: %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+
+	if err := client.allowFetch(""); err != nil {
+		t.Errorf("probe should be allowed: %v", err)
+	}
+	if err := client.allowFetch(""); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("only a single probe should be allowed, got: %v", err)
+	}
+
+	// probe caller gives up: another caller may probe
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.recordFetch(ctx, "", context.Canceled)
+
+	if err := client.allowFetch(""); err != nil {
+		t.Errorf("new probe should be allowed: %v", err)
+	}
+}
+
+// go test -run TestNegativeCache -count 1 ./clientcredentials
+func TestNegativeCache(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+	down.Store(true)
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 0)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return strings.HasPrefix(t, "token-") })
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:            ts.URL,
+		ClientID:            "clientID",
+		ClientSecret:        "clientSecret",
+		TimeSource:          clock.Now,
+		DisableSingleFlight: true,
+		NegativeCacheTTL:    5 * time.Second,
+	})
+
+	_, errFirst := send(client, srv.URL)
+	if errFirst == nil {
+		t.Errorf("expected error")
+	}
+
+	// cached error
+
+	down.Store(false)
+
+	_, errCached := send(client, srv.URL)
+	if errCached == nil || !strings.Contains(errCached.Error(), "negative cache") {
+		t.Errorf("expected cached error, got: %v", errCached)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("negative cache hit token server: access count: %d", tokenServerStat.count)
+	}
+
+	// cached error expires
+
+	clock.Advance(5 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestNegativeCacheTokenRequest -count 1 ./clientcredentials
+func TestNegativeCacheTokenRequest(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	// the token server refuses the scope "bad"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenServerStat.inc()
+		r.ParseForm()
+		if formParam(r, "scope") == "bad" {
+			httpJSON(w, `{"error":"invalid_scope"}`, http.StatusBadRequest)
+			return
+		}
+		httpJSON(w, `{"access_token":"abc","expires_in":300}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	var changes []string
+
+	client := New(Options{
+		TokenURL:                ts.URL,
+		ClientID:                "clientID",
+		ClientSecret:            "clientSecret",
+		TimeSource:              clock.Now,
+		DisableSingleFlight:     true,
+		NegativeCacheTTL:        5 * time.Second,
+		CircuitBreakerThreshold: 1,
+		OnCircuitBreakerStateChange: func(from, to CircuitState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+
+	bad := WithTokenRequest(context.TODO(), TokenRequest{Scope: "bad"})
+
+	for range 2 {
+		if _, errSend := sendWithContext(bad, client, srv.URL); !errors.Is(errSend, ErrInvalidScope) {
+			t.Errorf("expected invalid scope, got: %v", errSend)
+		}
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("negative cache hit token server: access count: %d", tokenServerStat.count)
+	}
+
+	// the bad token request must not block the default token
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if len(changes) != 0 {
+		t.Errorf("request error changed breaker state: %v", changes)
+	}
+}
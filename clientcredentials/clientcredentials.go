@@ -3,13 +3,17 @@ package clientcredentials
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/udhos/oauth2/token"
-	cc "github.com/udhos/oauth2clientcredentials/clientcredentials"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -25,10 +29,141 @@ type Options struct {
 	ClientSecret string
 	Scope        string
 
+	// Resource lists the resource indicators defined by RFC 8707, sent as
+	// repeated resource parameters on the token request.
+	Resource []string
+
+	// Audience is sent as the audience parameter on the token request,
+	// as required by Auth0, Okta and Keycloak.
+	Audience string
+
+	// TokenRequestParams holds extra form parameters for the token
+	// request. Parameters defined by other options, like scope, audience,
+	// resource and client authentication, take precedence.
+	TokenRequestParams url.Values
+
+	// TokenRequestHeader holds extra headers for the token request.
+	// Content-Type, Accept and the client authentication header take
+	// precedence.
+	TokenRequestHeader http.Header
+
+	// AuthMethod defines how the client authenticates to the token server.
+	// If undefined, defaults to AuthMethodTLSClientAuth in mTLS mode (see
+	// ClientCertificate), and to AuthMethodPost otherwise.
+	AuthMethod AuthMethod
+
+	// PrivateKey signs the client assertion for AuthMethodPrivateKeyJWT.
+	// RSA, ECDSA (P-256, P-384, P-521) and Ed25519 keys are supported.
+	PrivateKey crypto.Signer
+
+	// PrivateKeyPEM is the PEM-encoded private key for
+	// AuthMethodPrivateKeyJWT, used when PrivateKey is undefined.
+	// See ParsePrivateKeyPEM.
+	PrivateKeyPEM []byte
+
+	// AssertionAlgorithm is the JWS algorithm for signing the client
+	// assertion, like "PS256". If undefined, for AuthMethodPrivateKeyJWT
+	// it is derived from the key: RS256 for RSA, ES256/ES384/ES512 for
+	// ECDSA, EdDSA for Ed25519. For AuthMethodClientSecretJWT it may be
+	// HS256 (default), HS384 or HS512.
+	AssertionAlgorithm string
+
+	// AssertionKeyID is the optional kid header of the client assertion,
+	// identifying the key registered with the token server.
+	AssertionKeyID string
+
+	// AssertionAudience is the aud claim of the client assertion.
+	// If undefined, defaults to TokenURL.
+	AssertionAudience string
+
+	// AssertionLifetime is the client assertion validity, from iat to exp.
+	// 0 defaults to 1 minute.
+	AssertionLifetime time.Duration
+
+	// AssertionJTI generates the unique jti claim of every client assertion.
+	// If undefined, defaults to DefaultAssertionJTI.
+	AssertionJTI func() string
+
 	// HTTPClient is the HTTP client to use to make requests.
-	// If nil, http.DefaultClient is used.
+	// If nil, http.DefaultClient is used, except in mTLS mode, where
+	// separate HTTP clients presenting the client certificate are created
+	// for the token endpoint and for the resource requests.
+	// In mTLS mode, a copy of HTTPClient presenting the client certificate
+	// is used, thus HTTPClient must be *http.Client with nil Transport or
+	// *http.Transport, otherwise every request fails. In order to use
+	// another HTTPDoer, configure the certificate on it, leaving options
+	// ClientCertificate and GetClientCertificate undefined.
 	HTTPClient HTTPDoer
 
+	// ClientCertificate is the static client certificate for mutual TLS.
+	// Defining either ClientCertificate or GetClientCertificate enables
+	// mTLS mode, meant for AuthMethodTLSClientAuth and
+	// AuthMethodSelfSignedTLSClientAuth. The certificate is presented both
+	// to the token endpoint and to the resource servers, so that the
+	// certificate-bound tokens defined by RFC 8705 are accepted.
+	ClientCertificate *tls.Certificate
+
+	// GetClientCertificate provides the client certificate for mutual TLS,
+	// taking precedence over ClientCertificate. See CertificateReloader
+	// for a certificate reloaded from PEM files.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// TLSConfig is the optional base TLS configuration for mTLS mode, for
+	// instance to define RootCAs. The client certificate is added to it.
+	TLSConfig *tls.Config
+
+	// MTLSEndpointAliases holds the mtls_endpoint_aliases from the
+	// authorization server metadata (RFC 8705 section 5). In mTLS mode,
+	// the alias "token_endpoint", if defined, replaces TokenURL.
+	MTLSEndpointAliases map[string]string
+
+	// TokenResponseBodyLimit is the maximum size of the token server
+	// response body, error responses included, which are parsed up to
+	// this size. 0 defaults to DefaultTokenResponseBodyLimit.
+	TokenResponseBodyLimit int64
+
+	// OnTokenResponse, if defined, is called with every token response
+	// successfully retrieved from the token server, in order to expose
+	// fields like token_type, scope, refresh_token, id_token and the
+	// vendor extensions in TokenResponse.Extra.
+	OnTokenResponse func(resp *TokenResponse)
+
+	// ExpectedTokenType, if defined, is the token_type the token server
+	// must return, compared case-insensitively. A token response with
+	// another token_type fails with ErrTokenTypeMismatch. A missing
+	// token_type is taken as Bearer. In DPoP mode, defaults to "DPoP",
+	// since a Bearer token is not bound to the key (RFC 9449, section 5).
+	ExpectedTokenType string
+
+	// AttachToken attaches the token to the request.
+	// If undefined, defaults to DefaultAttachToken, which sets the
+	// Authorization header with the scheme from the token_type.
+	// See AttachHeader and AttachQuery for alternatives.
+	AttachToken func(req *http.Request, t token.Token)
+
+	// DPoP enables DPoP proof-of-possession (RFC 9449). A fresh DPoP proof,
+	// signed with DPoPKey, is attached to the token request and to every
+	// resource request, and the token is sent with the scheme from the
+	// token_type returned by the token server, which must be "DPoP" unless
+	// ExpectedTokenType says otherwise.
+	// When a server demands a nonce (use_dpop_nonce), the request is
+	// retried once with the nonce from the DPoP-Nonce header, and the nonce
+	// is cached per server for later proofs. A resource server refusal
+	// demanding a nonce does not count as a bad token.
+	//
+	// Since the token is bound to the key, clients sharing a cache across
+	// processes must share the same DPoPKey.
+	DPoP bool
+
+	// DPoPKey signs the DPoP proofs. RSA, ECDSA (P-256, P-384, P-521) and
+	// Ed25519 keys are supported. If undefined in DPoP mode, a P-256 key is
+	// generated for the client.
+	DPoPKey crypto.Signer
+
+	// DPoPAlgorithm is the JWS algorithm for signing the DPoP proofs.
+	// If undefined, it is derived from the key, as for AssertionAlgorithm.
+	DPoPAlgorithm string
+
 	// IsTokenStatusCodeOk defines custom function to check whether the
 	// token server response status is OK.
 	// If undefined, defaults to nil, which means any 2xx status is OK.
@@ -43,8 +178,30 @@ type Options struct {
 	//
 	SoftExpireInSeconds int
 
+	// Cache stores the token.
+	// If undefined, each client creates its own memory cache, so that
+	// clients with distinct TokenURL, ClientID or Scope never mix up
+	// their tokens. In order to share a memory cache between clients,
+	// explicitly set it to the same cache, for instance
+	// token.DefaultTokenCache.
+	// TokenCache implementations are adapted to token.TokenCacheV2 with
+	// token.AdaptTokenCache.
+	// Tokens for distinct Resource, Audience, TokenRequestParams or
+	// TokenRequestHeader are stored under distinct keys, except when the
+	// cache only implements TokenCache, which holds a single token.
 	Cache token.TokenCache
 
+	// CacheV2 stores the token, with context-aware methods.
+	// If defined, takes precedence over Cache.
+	CacheV2 token.TokenCacheV2
+
+	// MaxTokenRequests bounds how many tokens selected with
+	// WithTokenRequest the client keeps. When exceeded, the least recently
+	// used token is deleted from the cache. The token defined by the
+	// options is never evicted.
+	// 0 defaults to 100. -1 means unbounded.
+	MaxTokenRequests int
+
 	// Time source used to check token expiration.
 	// If unspecified, defaults to time.Now().
 	TimeSource func() time.Time
@@ -62,6 +219,151 @@ type Options struct {
 	// If undefined, defaults to DefaulIsBadTokenStatus that just checks
 	// for status 401.
 	IsBadTokenStatus func(status int) bool
+
+	// RetryBadToken is the maximum number of times a request refused
+	// with bad token status (see IsBadTokenStatus) is replayed with a
+	// fresh token. The refused response is discarded, a new token is
+	// retrieved through singleflight, and the request is sent again.
+	// 0 (default) disables retry, and the refused response is
+	// returned to the caller.
+	RetryBadToken int
+
+	// RetryBadTokenMethods lists the request methods allowed for retry.
+	// If undefined, defaults to DefaultRetryBadTokenMethods.
+	RetryBadTokenMethods []string
+
+	// RetryBadTokenBodyLimit is the maximum size of request body to buffer
+	// for replay, when the request does not provide GetBody.
+	// Requests with larger bodies are not replayed.
+	// 0 defaults to 64 KiB. Set to -1 to never buffer.
+	RetryBadTokenBodyLimit int64
+
+	// OnRetryBadToken, if defined, is called before every retry with the
+	// request, the refused response status and the attempt number,
+	// starting from 1.
+	OnRetryBadToken func(req *http.Request, status, attempt int)
+
+	// RetryTokenAttempts is the maximum number of attempts to fetch a token
+	// from the token server. Failed attempts are retried with exponential
+	// backoff, but only for errors classified as retryable: transient
+	// network errors (timeout, connection refused or reset, unexpected EOF)
+	// and statuses accepted by IsTokenStatusRetryable.
+	// 0 (default) or 1 makes a single attempt.
+	RetryTokenAttempts int
+
+	// IsTokenStatusRetryable defines custom function to check whether a
+	// failed token server response status is worth retrying.
+	// If undefined, defaults to DefaultIsTokenStatusRetryable.
+	IsTokenStatusRetryable func(status int) bool
+
+	// RetryTokenMinBackoff is the wait before the first retry.
+	// The wait doubles on every retry. 0 defaults to 100 milliseconds.
+	RetryTokenMinBackoff time.Duration
+
+	// RetryTokenMaxBackoff is the maximum wait between retries.
+	// A Retry-After response header asking for a longer wait stops the
+	// retries, since the shared token fetch would hold every caller.
+	// 0 defaults to 5 seconds.
+	RetryTokenMaxBackoff time.Duration
+
+	// RetryTokenJitter is the maximum random time added to every wait,
+	// in order to spread retries from many clients.
+	// 0 defaults to 100 milliseconds. Set to -1 to no jitter.
+	RetryTokenJitter time.Duration
+
+	// RetryTokenBudget is the maximum total time spent fetching a token,
+	// including all attempts and waits. A retry that would exceed the
+	// budget is not attempted. 0 (default) means no budget, so only
+	// RetryTokenAttempts and the request context bound the retries.
+	RetryTokenBudget time.Duration
+
+	// OnRetryToken, if defined, is called before every token fetch retry
+	// with the attempt that failed, starting from 1, the wait before the
+	// next attempt and the failure.
+	OnRetryToken func(attempt int, wait time.Duration, err error)
+
+	// CircuitBreakerThreshold is the number of consecutive token fetch
+	// failures that opens the circuit breaker. While open, token fetches
+	// fail fast with *CircuitOpenError, without calling the token server.
+	// After CircuitBreakerOpenTimeout, the breaker turns half-open and
+	// lets a single probe fetch through: success closes the breaker,
+	// failure opens it again. 0 (default) disables the breaker.
+	// Since the breaker tracks the token server health, refusals caused
+	// by the token request, like invalid_scope, invalid_request and
+	// invalid_target, count as success.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerOpenTimeout is how long the breaker stays open before
+	// probing the token server. 0 defaults to 30 seconds.
+	CircuitBreakerOpenTimeout time.Duration
+
+	// OnCircuitBreakerStateChange, if defined, is called on every circuit
+	// breaker state change.
+	OnCircuitBreakerStateChange func(from, to CircuitState)
+
+	// NegativeCacheTTL is how long the last token fetch error is cached,
+	// per token selected with WithTokenRequest.
+	// While cached, fetches of that token fail fast with the cached error,
+	// without calling the token server. 0 (default) disables negative
+	// caching.
+	NegativeCacheTTL time.Duration
+
+	// StaleWhileRevalidate enables serving a stale token, that is, a token
+	// within the soft expire window but not yet hard expired, while a new
+	// token is fetched asynchronously. Thus requests keep flowing while
+	// the token server is slow or down, and only fail once the token
+	// reaches its hard deadline. Asynchronous fetches are always guarded
+	// with singleflight, and throttled after failures, as defined by
+	// options RevalidateMinBackoff and RevalidateMaxBackoff.
+	StaleWhileRevalidate bool
+
+	// RevalidateMinBackoff is the minimum interval between asynchronous
+	// fetches (see StaleWhileRevalidate) after a failed one. The interval
+	// doubles on every failure, up to RevalidateMaxBackoff, and is reset
+	// on success. Thus a failing token server is not hit at the request
+	// rate. 0 defaults to 1 second.
+	RevalidateMinBackoff time.Duration
+
+	// RevalidateMaxBackoff is the maximum interval between asynchronous
+	// fetches after failures. 0 defaults to 1 minute.
+	RevalidateMaxBackoff time.Duration
+
+	// OnStaleToken, if defined, is called whenever a stale token is served
+	// (see StaleWhileRevalidate) with the time remaining until the token
+	// hard expiration.
+	OnStaleToken func(remain time.Duration)
+
+	// OnRevalidate, if defined, is called with the outcome of every
+	// asynchronous fetch started by StaleWhileRevalidate. The error is
+	// nil on success.
+	OnRevalidate func(err error)
+
+	// BackgroundRefresh enables a background goroutine that renews the
+	// token ahead of its soft expiration, so that requests do not pay
+	// for the token server latency. Use Close to stop it.
+	BackgroundRefresh bool
+
+	// BackgroundRefreshJitter is the maximum random time subtracted
+	// from the scheduled renewal, in order to spread renewals from many
+	// clients. 0 defaults to 5 seconds. Set to -1 to no jitter.
+	BackgroundRefreshJitter time.Duration
+
+	// BackgroundRefreshMinBackoff is the initial wait before retrying
+	// a failed background renewal. The wait doubles on every failure.
+	// 0 defaults to 1 second.
+	BackgroundRefreshMinBackoff time.Duration
+
+	// BackgroundRefreshMaxBackoff is the maximum wait before retrying
+	// a failed background renewal. It is also the interval for checking
+	// tokens without expiration. 0 defaults to 1 minute.
+	BackgroundRefreshMaxBackoff time.Duration
+
+	// After waits for the duration to elapse and then sends the current
+	// time on the returned channel. It is used by the background refresher,
+	// by token fetch retries and by the distributed lock polling, along
+	// with TimeSource, thus both can be replaced by a fake clock.
+	// If unspecified, defaults to time.After.
+	After func(d time.Duration) <-chan time.Time
 }
 
 // DefaulIsBadTokenStatus is used as default function when option IsBadTokenStatus
@@ -72,24 +374,92 @@ func DefaulIsBadTokenStatus(status int) bool {
 
 // Client is context for invokations with client-credentials flow.
 type Client struct {
-	options Options
-	group   singleflight.Group
+	options    Options
+	errOptions error // invalid options, reported by every request
+	group      singleflight.Group
+	fetchMutex sync.Mutex
+	fetches    map[string]*sharedFetch // singleflight fetches by key
+	cache      token.TokenCacheV2
+	breaker    circuitBreaker
+
+	defaultTarget tokenTarget // token defined by options
+	keyed         bool        // cache supports keys
+	keys          *keyLRU     // tokens selected with WithTokenRequest
+
+	resourceHTTPClient HTTPDoer          // sends requests from Do
+	resourceTransport  http.RoundTripper // default Transport.Base
+
+	detectedAuthMethod atomic.Pointer[AuthMethod] // found by AuthMethodAuto
+
+	privateKeyOnce sync.Once // parses PrivateKeyPEM
+	parsedKey      crypto.Signer
+	parsedKeyErr   error
+
+	dpopKeyOnce         sync.Once // generates DPoP key
+	generatedDPoPKey    crypto.Signer
+	generatedDPoPKeyErr error
+	dpopNonces          dpopNonces
+
+	revalidateMutex   sync.Mutex
+	revalidateBackoff time.Duration // grows with failures
+	revalidateAt      time.Time     // next asynchronous fetch allowed
+
+	refreshCancel context.CancelFunc
+	refreshDone   chan struct{}
 }
 
 // New creates a client.
 func New(options Options) *Client {
+	var resourceHTTPClient HTTPDoer
+	var resourceTransport http.RoundTripper = http.DefaultTransport
+	var errOptions error
+	if options.mtlsEnabled() {
+		if options.HTTPClient == nil {
+			// separate clients, so that tuning one does not affect the other
+			options.HTTPClient = &http.Client{Transport: options.mtlsTransport()}
+			resourceTransport = options.mtlsTransport()
+			resourceHTTPClient = &http.Client{Transport: resourceTransport}
+		} else if hc, errMTLS := options.mtlsHTTPClient(); errMTLS != nil {
+			errOptions = errMTLS
+		} else {
+			options.HTTPClient = hc
+			resourceTransport = hc.Transport
+		}
+	}
 	if options.HTTPClient == nil {
 		options.HTTPClient = http.DefaultClient
 	}
+	if resourceHTTPClient == nil {
+		resourceHTTPClient = options.HTTPClient
+	}
+	if options.AuthMethod == "" {
+		if options.mtlsEnabled() {
+			options.AuthMethod = AuthMethodTLSClientAuth
+		} else {
+			options.AuthMethod = AuthMethodPost
+		}
+	}
+	if options.AssertionLifetime == 0 {
+		options.AssertionLifetime = time.Minute
+	}
+	if options.AssertionJTI == nil {
+		options.AssertionJTI = DefaultAssertionJTI
+	}
+	if options.AttachToken == nil {
+		options.AttachToken = DefaultAttachToken
+	}
+	if options.ExpectedTokenType == "" && options.DPoP {
+		options.ExpectedTokenType = "DPoP"
+	}
+	if options.TokenResponseBodyLimit == 0 {
+		options.TokenResponseBodyLimit = DefaultTokenResponseBodyLimit
+	}
 	switch options.SoftExpireInSeconds {
 	case 0:
 		options.SoftExpireInSeconds = 10
 	case -1:
 		options.SoftExpireInSeconds = 0
 	}
-	if options.Cache == nil {
-		options.Cache = token.DefaultTokenCache
-	}
 	if options.TimeSource == nil {
 		options.TimeSource = time.Now
 	}
@@ -99,10 +469,83 @@ func New(options Options) *Client {
 	if options.IsBadTokenStatus == nil {
 		options.IsBadTokenStatus = DefaulIsBadTokenStatus
 	}
-	options.Cache.Expire()
-	return &Client{
-		options: options,
+	if options.RetryBadTokenMethods == nil {
+		options.RetryBadTokenMethods = DefaultRetryBadTokenMethods
+	}
+	if options.CircuitBreakerOpenTimeout == 0 {
+		options.CircuitBreakerOpenTimeout = 30 * time.Second
+	}
+	if options.IsTokenStatusRetryable == nil {
+		options.IsTokenStatusRetryable = DefaultIsTokenStatusRetryable
 	}
+	if options.RetryTokenMinBackoff == 0 {
+		options.RetryTokenMinBackoff = 100 * time.Millisecond
+	}
+	if options.RetryTokenMaxBackoff == 0 {
+		options.RetryTokenMaxBackoff = 5 * time.Second
+	}
+	switch options.RetryTokenJitter {
+	case 0:
+		options.RetryTokenJitter = 100 * time.Millisecond
+	case -1:
+		options.RetryTokenJitter = 0
+	}
+	switch options.BackgroundRefreshJitter {
+	case 0:
+		options.BackgroundRefreshJitter = 5 * time.Second
+	case -1:
+		options.BackgroundRefreshJitter = 0
+	}
+	if options.RevalidateMinBackoff == 0 {
+		options.RevalidateMinBackoff = time.Second
+	}
+	if options.RevalidateMaxBackoff == 0 {
+		options.RevalidateMaxBackoff = time.Minute
+	}
+	if options.BackgroundRefreshMinBackoff == 0 {
+		options.BackgroundRefreshMinBackoff = time.Second
+	}
+	if options.BackgroundRefreshMaxBackoff == 0 {
+		options.BackgroundRefreshMaxBackoff = time.Minute
+	}
+	if options.After == nil {
+		options.After = time.After
+	}
+	switch options.RetryBadTokenBodyLimit {
+	case 0:
+		options.RetryBadTokenBodyLimit = 64 * 1024
+	case -1:
+		options.RetryBadTokenBodyLimit = 0
+	}
+	keyed := true
+	if options.CacheV2 == nil {
+		if options.Cache == nil {
+			options.Cache = token.NewMemoryCache()
+		}
+		_, keyed = options.Cache.(token.TokenCacheV2)
+		options.CacheV2 = token.AdaptTokenCache(options.Cache)
+	}
+	switch options.MaxTokenRequests {
+	case 0:
+		options.MaxTokenRequests = 100
+	case -1:
+		options.MaxTokenRequests = 0
+	}
+	c := &Client{
+		options:            options,
+		errOptions:         errOptions,
+		cache:              options.CacheV2,
+		defaultTarget:      options.newTokenTarget(keyed),
+		keyed:              keyed,
+		keys:               newKeyLRU(options.MaxTokenRequests),
+		resourceHTTPClient: resourceHTTPClient,
+		resourceTransport:  resourceTransport,
+	}
+	c.cache.ExpireToken(context.Background(), c.defaultTarget.key)
+	if options.BackgroundRefresh {
+		c.startRefresher()
+	}
+	return c
 }
 
 func (c *Client) errorf(format string, v ...any) {
@@ -116,122 +559,342 @@ func (c *Client) debugf(format string, v ...any) {
 }
 
 // Do sends an HTTP request.
+//
+// Token retrieval honors the request context: if the context is canceled
+// or its deadline expires while the token is being fetched, Do returns
+// promptly with the context error.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 
-	accessToken, errToken := c.getToken()
+	t, errToken := c.getToken(req.Context())
 	if errToken != nil {
 		return nil, errToken
 	}
 
-	resp, errResp := c.send(req, accessToken)
-	if errResp != nil {
-		return resp, errResp
+	return c.sendWithRetry(req, t, c.send)
+}
+
+// checkBadToken expires the cached token if the server refused it.
+// It reports whether the status is bad token.
+func (c *Client) checkBadToken(ctx context.Context, status int, accessToken string) bool {
+	if !c.options.IsBadTokenStatus(status) {
+		return false
 	}
+	c.expireToken(ctx, accessToken)
+	return true
+}
 
-	if c.options.IsBadTokenStatus(resp.StatusCode) {
-		//
-		// the server refused our token, so we expire it in order to
-		// renew it at the next invokation.
-		//
-		if err := c.options.Cache.Expire(); err != nil {
-			c.errorf("cache expire error: %v", err)
+// expireToken invalidates the refused token, in order to renew it at
+// the next invokation.
+//
+// If the cache supports token.TokenCacheV2CompareExpire, the token is
+// expired only if the cache still holds the refused token. Thus a late
+// refusal for an old token does not discard a token that has just been
+// renewed by another goroutine or process.
+func (c *Client) expireToken(ctx context.Context, accessToken string) {
+	if cae, ok := c.cache.(token.TokenCacheV2CompareExpire); ok {
+		expired, err := cae.CompareAndExpireToken(ctx, c.cacheKey(ctx), accessToken)
+		if err != nil {
+			c.errorf("cache compare-and-expire error: %v", err)
+			return
 		}
+		c.debugf("cache compare-and-expire: expired=%t", expired)
+		return
+	}
+	if err := c.cache.ExpireToken(ctx, c.cacheKey(ctx)); err != nil {
+		c.errorf("cache expire error: %v", err)
+	}
+}
+
+func (c *Client) send(req *http.Request, t token.Token) (*http.Response, error) {
+	return c.sendToken(req, t, c.resourceHTTPClient.Do)
+}
+
+// sendToken attaches the token to the request and sends it with do.
+// In DPoP mode, it also attaches the DPoP proof.
+func (c *Client) sendToken(req *http.Request, t token.Token, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	c.options.AttachToken(req, t)
+	if !c.options.DPoP {
+		return do(req)
 	}
+	return c.sendWithDPoP(req, t.Value, isResourceNonceError, do)
+}
 
-	return resp, errResp
+func (c *Client) getToken(ctx context.Context) (token.Token, error) {
+	if c.errOptions != nil {
+		return token.Token{}, c.errOptions
+	}
+	if err := c.selectToken(ctx); err != nil {
+		return token.Token{}, err
+	}
+	t, state := c.lookupToken(ctx)
+	switch state {
+	case tokenValid:
+		return t, nil
+	case tokenStale:
+		if c.options.StaleWhileRevalidate {
+			c.serveStale(ctx, t)
+			return t, nil
+		}
+	}
+	return c.fetchToken(ctx)
 }
 
-func (c *Client) send(req *http.Request, accessToken string) (*http.Response, error) {
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	return c.options.HTTPClient.Do(req)
+// tokenState classifies the cached token.
+type tokenState int
+
+const (
+	tokenInvalid tokenState = iota // missing or hard expired
+	tokenStale                     // soft expired, but not hard expired
+	tokenValid                     // not soft expired
+)
+
+// cachedToken retrieves valid token from cache.
+func (c *Client) cachedToken(ctx context.Context) (token.Token, bool) {
+	t, state := c.lookupToken(ctx)
+	return t, state == tokenValid
 }
 
-func (c *Client) getToken() (string, error) {
-	t, errCache := c.options.Cache.Get()
+// lookupToken retrieves token from cache, classifying it as valid,
+// stale or invalid.
+func (c *Client) lookupToken(ctx context.Context) (token.Token, tokenState) {
+	t, errCache := c.cache.GetToken(ctx, c.cacheKey(ctx))
 	if errCache != nil {
 		c.errorf("cache get error: %v", errCache)
-		return c.fetchToken()
+		return token.Token{}, tokenInvalid
 	}
 	softExpire := time.Duration(c.options.SoftExpireInSeconds) * time.Second
 	now := c.options.TimeSource()
 	if t.IsValid(now, softExpire, c.debugf) {
 		c.debugf("found valid cached token")
-		return t.Value, nil
+		return t, tokenValid
+	}
+	if t.IsHardValid(now) {
+		c.debugf("found stale cached token: remain=%v", t.Deadline.Sub(now))
+		return t, tokenStale
 	}
 	c.debugf("NO valid cached token")
-	return c.fetchToken()
+	return token.Token{}, tokenInvalid
+}
+
+// serveStale reports the stale token and starts its asynchronous renewal.
+func (c *Client) serveStale(ctx context.Context, t token.Token) {
+	remain := t.Deadline.Sub(c.options.TimeSource())
+	c.debugf("serving stale token while revalidating: remain=%v", remain)
+	if c.options.OnStaleToken != nil {
+		c.options.OnStaleToken(remain)
+	}
+	c.revalidate(ctx)
+}
+
+// revalidate fetches a new token asynchronously, guarded with singleflight,
+// so that concurrent requests served with the stale token start a single
+// fetch. After a failed fetch, the next one waits for the backoff interval.
+func (c *Client) revalidate(ctx context.Context) {
+	if wait := c.revalidateWait(); wait > 0 {
+		c.debugf("revalidate stale token: throttled for %v", wait)
+		return
+	}
+
+	detached := context.WithoutCancel(ctx)
+
+	f := func() (any, error) {
+		t, err := c.fetchTokenRaw(detached)
+		c.revalidateDone(err)
+		if err != nil {
+			c.errorf("revalidate stale token: %v", err)
+		} else {
+			c.debugf("revalidate stale token: renewed")
+		}
+		if c.options.OnRevalidate != nil {
+			c.options.OnRevalidate(err)
+		}
+		return t, err
+	}
+
+	// the result channel is buffered, so it can be safely ignored
+	c.group.DoChan(c.cacheKey(ctx), f)
+}
+
+// revalidateWait finds how long until the next asynchronous fetch is allowed.
+func (c *Client) revalidateWait() time.Duration {
+	c.revalidateMutex.Lock()
+	defer c.revalidateMutex.Unlock()
+	return c.revalidateAt.Sub(c.options.TimeSource())
+}
+
+// revalidateDone updates the revalidation backoff with the fetch outcome.
+func (c *Client) revalidateDone(err error) {
+	c.revalidateMutex.Lock()
+	defer c.revalidateMutex.Unlock()
+	if err == nil {
+		c.revalidateBackoff = 0
+		c.revalidateAt = time.Time{}
+		return
+	}
+	c.revalidateBackoff = nextBackoff(c.revalidateBackoff, c.options.RevalidateMinBackoff,
+		c.options.RevalidateMaxBackoff)
+	c.revalidateAt = c.options.TimeSource().Add(c.revalidateBackoff)
 }
 
 // fetchTokens retrieves new token and saves into cache, guarded with singleflight.
-func (c *Client) fetchToken() (string, error) {
+//
+// The shared singleflight fetch runs detached from the cancelation of
+// the caller that started it, so that a canceled caller does not abort
+// the fetch for the other waiters. Each caller still stops waiting as
+// soon as its own context is done. When the last waiter gives up, the
+// fetch is canceled and forgotten, so that a stalled token server does
+// not hold up the next callers.
+func (c *Client) fetchToken(ctx context.Context) (token.Token, error) {
 
 	if c.options.DisableSingleFlight {
-		return c.fetchTokenRaw()
+		return c.fetchTokenRaw(ctx)
 	}
 
-	key := ""
+	key := c.cacheKey(ctx)
+
+	shared := c.joinFetch(ctx, key)
+	defer c.leaveFetch(key, shared)
 
 	f := func() (any, error) {
-		return c.fetchTokenRaw()
+		return c.fetchTokenRaw(shared.ctx)
 	}
 
-	result, errFetch, _ := c.group.Do(key, f)
-	if errFetch != nil {
-		return "", errFetch
+	var result any
+
+	select {
+	case <-ctx.Done():
+		return token.Token{}, ctx.Err()
+	case r := <-c.group.DoChan(key, f):
+		if r.Err != nil {
+			return token.Token{}, r.Err
+		}
+		result = r.Val
 	}
 
-	str, isStr := result.(string)
-	if !isStr {
-		return "", fmt.Errorf("non-string result: type:%[1]T value:%[1]v", result)
+	t, isToken := result.(token.Token)
+	if !isToken {
+		return token.Token{}, fmt.Errorf("non-token result: type:%[1]T value:%[1]v", result)
 	}
 
-	return str, nil
+	return t, nil
 }
 
-// fetchTokensRaw retrieves new token and saves into cache.
-func (c *Client) fetchTokenRaw() (string, error) {
+// sharedFetch holds the context of the singleflight fetch for a key.
+type sharedFetch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
 
-	begin := time.Now()
+// joinFetch registers a waiter for the singleflight fetch of key.
+func (c *Client) joinFetch(ctx context.Context, key string) *sharedFetch {
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+	shared, found := c.fetches[key]
+	if !found {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		shared = &sharedFetch{ctx: fetchCtx, cancel: cancel}
+		if c.fetches == nil {
+			c.fetches = map[string]*sharedFetch{}
+		}
+		c.fetches[key] = shared
+	}
+	shared.waiters++
+	return shared
+}
+
+// leaveFetch unregisters a waiter. The last waiter cancels the fetch,
+// if still running, and forgets it, so that the next caller starts a
+// new fetch instead of joining the abandoned one.
+func (c *Client) leaveFetch(key string, shared *sharedFetch) {
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+	shared.waiters--
+	if shared.waiters > 0 {
+		return
+	}
+	shared.cancel()
+	c.group.Forget(key)
+	delete(c.fetches, key)
+}
+
+// fetchTokensRaw retrieves new token and saves into cache, guarded by
+// the negative cache and circuit breaker, if enabled.
+func (c *Client) fetchTokenRaw(ctx context.Context) (token.Token, error) {
+	if c.errOptions != nil {
+		return token.Token{}, c.errOptions
+	}
+	key := c.cacheKey(ctx)
+	if err := c.allowFetch(key); err != nil {
+		c.debugf("fetchToken: fail fast: %v", err)
+		return token.Token{}, err
+	}
+	t, err := c.fetchTokenSource(ctx)
+	c.recordFetch(ctx, key, err)
+	return t, err
+}
+
+// fetchTokenSource retrieves new token and saves into cache.
+// If the cache provides a distributed lock, the fetch is guarded by it.
+func (c *Client) fetchTokenSource(ctx context.Context) (token.Token, error) {
+	if locker, isLocker := c.cache.(token.TokenCacheLocker); isLocker {
+		return c.fetchTokenLocked(ctx, locker)
+	}
+	return c.fetchTokenUnlocked(ctx)
+}
 
-	reqOptions := cc.RequestOptions{
-		TokenURL:       c.options.TokenURL,
-		ClientID:       c.options.ClientID,
-		ClientSecret:   c.options.ClientSecret,
-		Scope:          c.options.Scope,
-		HTTPClient:     c.options.HTTPClient,
-		IsStatusCodeOK: c.options.IsTokenStatusCodeOk,
+// fetchTokenUnlocked retrieves new token and saves into cache.
+func (c *Client) fetchTokenUnlocked(ctx context.Context) (token.Token, error) {
+	newToken, errFetch := c.requestToken(ctx)
+	if errFetch != nil {
+		return token.Token{}, errFetch
 	}
 
-	if c.options.HTTPClient != nil {
-		// do not assign nil to interface
-		reqOptions.HTTPClient = c.options.HTTPClient
+	c.debugf("saving new token")
+	if err := c.cache.PutToken(ctx, c.cacheKey(ctx), newToken); err != nil {
+		c.errorf("cache put error: %v", err)
 	}
 
-	resp, errSend := cc.SendRequest(context.TODO(), reqOptions)
+	return newToken, nil
+}
+
+// requestTokenOnce makes a single attempt to retrieve new token from
+// token server. It also returns the token server response, if any,
+// in order to classify the failure. The response body is already closed.
+func (c *Client) requestTokenOnce(ctx context.Context) (token.Token, *http.Response, error) {
+
+	begin := time.Now()
+
+	tr, resp, errSend := c.sendTokenRequest(ctx)
 	if errSend != nil {
-		return "", errSend
+		return token.Token{}, resp, errSend
 	}
 
 	elap := time.Since(begin)
 
-	c.debugf("fetchToken: elapsed:%v token:%v", elap, resp)
+	c.debugf("fetchToken: elapsed:%v token_type:%s expires_in:%d scope:%s",
+		elap, tr.TokenType, tr.ExpiresIn, tr.Scope)
 
-	if resp.AccessToken == "" {
-		return "", fmt.Errorf("no access token in response")
+	if tr.AccessToken == "" {
+		return token.Token{}, resp, fmt.Errorf("no access token in response")
 	}
 
-	newToken := token.Token{
-		Value: resp.AccessToken,
+	if c.options.OnTokenResponse != nil {
+		c.options.OnTokenResponse(tr)
 	}
 
-	if resp.ExpiresIn != 0 {
-		newToken.SetExpiration(time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second))
+	if err := checkTokenType(c.options.ExpectedTokenType, tr.TokenType); err != nil {
+		return token.Token{}, resp, err
 	}
 
-	c.debugf("saving new token")
-	if err := c.options.Cache.Put(newToken); err != nil {
-		c.errorf("cache put error: %v", err)
+	newToken := token.Token{
+		Value: tr.AccessToken,
+		Type:  tr.TokenType,
+	}
+
+	if tr.ExpiresIn != 0 {
+		newToken.SetExpiration(c.options.TimeSource().Add(time.Duration(tr.ExpiresIn) * time.Second))
 	}
 
-	return newToken.Value, nil
+	return newToken, resp, nil
 }
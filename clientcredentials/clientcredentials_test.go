@@ -2,13 +2,16 @@ package clientcredentials
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -578,23 +581,788 @@ func TestLockedTokenServer(t *testing.T) {
 	}
 }
 
+// go test -run TestLateBadTokenKeepsRenewedToken -count 1 ./clientcredentials
+func TestLateBadTokenKeepsRenewedToken(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	softExpire := -1 // disable soft expire
+	timeSource := (func() time.Time)(nil)
+	disableSingleflight := false
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServerSequence(&tokenServerStat)
+	defer ts.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	old, errOld := client.getToken(context.TODO())
+	if errOld != nil {
+		t.Fatalf("get token: %v", errOld)
+	}
+
+	//
+	// someone else renews the token
+	//
+
+	renewed := token.Token{Value: "renewed"}
+	renewed.SetExpiration(time.Now().Add(time.Minute))
+	if err := client.cache.PutToken(context.TODO(), client.cacheKey(context.TODO()), renewed); err != nil {
+		t.Fatalf("cache put: %v", err)
+	}
+
+	//
+	// late refusal for the old token must not expire the renewed token
+	//
+
+	client.checkBadToken(context.TODO(), 401, old.Value)
+
+	current, errCurrent := client.getToken(context.TODO())
+	if errCurrent != nil {
+		t.Fatalf("get token: %v", errCurrent)
+	}
+	if current.Value != "renewed" {
+		t.Errorf("renewed token was discarded: current=%s", current.Value)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	//
+	// refusal for the current token expires it
+	//
+
+	client.checkBadToken(context.TODO(), 401, current.Value)
+
+	fresh, errFresh := client.getToken(context.TODO())
+	if errFresh != nil {
+		t.Fatalf("get token: %v", errFresh)
+	}
+	if fresh.Value != "token-2" {
+		t.Errorf("unexpected fresh token: %s", fresh.Value)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestClientsDoNotShareDefaultCache -count 1 ./clientcredentials
+func TestClientsDoNotShareDefaultCache(t *testing.T) {
+
+	statTokenA := serverStat{}
+	statTokenB := serverStat{}
+	statA := serverStat{}
+	statB := serverStat{}
+
+	tsA := newTokenServer(&statTokenA, "clientA", "secretA", "token-a", 60)
+	defer tsA.Close()
+
+	tsB := newTokenServer(&statTokenB, "clientB", "secretB", "token-b", 60)
+	defer tsB.Close()
+
+	srvA := newServer(&statA, func(t string) bool { return t == "token-a" })
+	defer srvA.Close()
+
+	srvB := newServer(&statB, func(t string) bool { return t == "token-b" })
+	defer srvB.Close()
+
+	clientA := New(Options{
+		TokenURL:     tsA.URL,
+		ClientID:     "clientA",
+		ClientSecret: "secretA",
+	})
+
+	clientB := New(Options{
+		TokenURL:     tsB.URL,
+		ClientID:     "clientB",
+		ClientSecret: "secretB",
+		Scope:        "scopeB",
+	})
+
+	for range 2 {
+		if _, errSend := send(clientA, srvA.URL); errSend != nil {
+			t.Errorf("send A: %v", errSend)
+		}
+		if _, errSend := send(clientB, srvB.URL); errSend != nil {
+			t.Errorf("send B: %v", errSend)
+		}
+	}
+
+	if statTokenA.count != 1 {
+		t.Errorf("unexpected token server A access count: %d", statTokenA.count)
+	}
+	if statTokenB.count != 1 {
+		t.Errorf("unexpected token server B access count: %d", statTokenB.count)
+	}
+	if statA.count != 2 {
+		t.Errorf("unexpected server A access count: %d", statA.count)
+	}
+	if statB.count != 2 {
+		t.Errorf("unexpected server B access count: %d", statB.count)
+	}
+}
+
+// go test -run TestClientsShareExplicitCache -count 1 ./clientcredentials
+func TestClientsShareExplicitCache(t *testing.T) {
+
+	statTokenA := serverStat{}
+	statTokenB := serverStat{}
+	stat := serverStat{}
+
+	tsA := newTokenServer(&statTokenA, "clientID", "clientSecret", "abc", 60)
+	defer tsA.Close()
+
+	tsB := newTokenServer(&statTokenB, "clientID", "clientSecret", "abc", 60)
+	defer tsB.Close()
+
+	srv := newServer(&stat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	clientA := New(Options{
+		TokenURL:     tsA.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Cache:        token.DefaultTokenCache,
+	})
+
+	clientB := New(Options{
+		TokenURL:     tsB.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Cache:        token.DefaultTokenCache,
+	})
+
+	if _, errSend := send(clientA, srv.URL); errSend != nil {
+		t.Errorf("send A: %v", errSend)
+	}
+	if _, errSend := send(clientB, srv.URL); errSend != nil {
+		t.Errorf("send B: %v", errSend)
+	}
+
+	if statTokenA.count != 1 {
+		t.Errorf("unexpected token server A access count: %d", statTokenA.count)
+	}
+	if statTokenB.count != 0 {
+		t.Errorf("client B did not reuse shared token: token server B access count: %d", statTokenB.count)
+	}
+}
+
+// go test -run TestLegacyTokenCache -count 1 ./clientcredentials
+func TestLegacyTokenCache(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, "clientID", "clientSecret", "abc", 60)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	legacy := &legacyCache{}
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Cache:        legacy,
+	})
+
+	for range 2 {
+		if _, errSend := send(client, srv.URL); errSend != nil {
+			t.Errorf("send: %v", errSend)
+		}
+	}
+
+	if legacy.t.Value != "abc" {
+		t.Errorf("token not stored in legacy cache: '%s'", legacy.t.Value)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 2 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// legacyCache implements only token.TokenCache, as third-party caches do.
+type legacyCache struct {
+	t     token.Token
+	mutex sync.Mutex
+}
+
+func (c *legacyCache) Get() (token.Token, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t, nil
+}
+
+func (c *legacyCache) Put(t token.Token) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.t = t
+	return nil
+}
+
+func (c *legacyCache) Expire() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.t.Expire()
+	return nil
+}
+
+// go test -run TestContextDeadline -count 1 ./clientcredentials
+func TestContextDeadline(t *testing.T) {
+	testContextDeadline(t, false)
+}
+
+// go test -run TestContextDeadlineDisableSingleFlight -count 1 ./clientcredentials
+func TestContextDeadlineDisableSingleFlight(t *testing.T) {
+	testContextDeadline(t, true)
+}
+
+func testContextDeadline(t *testing.T, disableSingleflight bool) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	release := make(chan struct{})
+
+	ts := newTokenServerStalled(&tokenServerStat, token, release)
+	defer ts.Close()
+	defer close(release)
+
+	validToken := func(t string) bool { return t == token }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	_, errSend := sendWithContext(ctx, client, srv.URL)
+	elapsed := time.Since(begin)
+
+	if !errors.Is(errSend, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", errSend)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("send did not honor context deadline: elapsed=%v", elapsed)
+	}
+	if serverStat.count != 0 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestContextCancel -count 1 ./clientcredentials
+func TestContextCancel(t *testing.T) {
+	testContextCancel(t, false)
+}
+
+// go test -run TestContextCancelDisableSingleFlight -count 1 ./clientcredentials
+func TestContextCancelDisableSingleFlight(t *testing.T) {
+	testContextCancel(t, true)
+}
+
+func testContextCancel(t *testing.T, disableSingleflight bool) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	release := make(chan struct{})
+
+	ts := newTokenServerStalled(&tokenServerStat, token, release)
+	defer ts.Close()
+	defer close(release)
+
+	validToken := func(t string) bool { return t == token }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	begin := time.Now()
+	_, errSend := sendWithContext(ctx, client, srv.URL)
+	elapsed := time.Since(begin)
+
+	if !errors.Is(errSend, context.Canceled) {
+		t.Errorf("expected context canceled, got: %v", errSend)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("send did not honor context cancelation: elapsed=%v", elapsed)
+	}
+	if serverStat.count != 0 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestSingleFlightCanceledWaiter -count 1 ./clientcredentials
+func TestSingleFlightCanceledWaiter(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+	disableSingleflight := false
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	release := make(chan struct{})
+
+	ts := newTokenServerStalled(&tokenServerStat, token, release)
+	defer ts.Close()
+
+	validToken := func(t string) bool { return t == token }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	//
+	// the first caller starts the shared fetch, then gives up.
+	//
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	canceled := make(chan error, 1)
+	go func() {
+		_, errSend := sendWithContext(ctx, client, srv.URL)
+		canceled <- errSend
+	}()
+
+	//
+	// the second caller joins the same shared fetch and keeps waiting.
+	//
+
+	waiter := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, errSend := send(client, srv.URL)
+		waiter <- errSend
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case errSend := <-canceled:
+		if !errors.Is(errSend, context.Canceled) {
+			t.Errorf("expected context canceled, got: %v", errSend)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("canceled caller did not return promptly")
+	}
+
+	close(release)
+
+	select {
+	case errSend := <-waiter:
+		if errSend != nil {
+			t.Errorf("waiter send: %v", errSend)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiter did not get token from shared fetch")
+	}
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 1 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestSingleFlightAbandonedFetch -count 1 ./clientcredentials
+func TestSingleFlightAbandonedFetch(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+	disableSingleflight := false
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	release := make(chan struct{})
+	stalled := atomic.Bool{}
+
+	// the first token request hangs until the client gives up
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenServerStat.inc()
+		if stalled.CompareAndSwap(false, true) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s"}`, token), http.StatusOK)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	validToken := func(t string) bool { return t == token }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel1()
+
+	if _, errSend := sendWithContext(ctx1, client, srv.URL); !errors.Is(errSend, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", errSend)
+	}
+
+	// the only waiter gave up: the next caller must start a new fetch
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+
+	if _, errSend := sendWithContext(ctx2, client, srv.URL); errSend != nil {
+		t.Errorf("send after abandoned fetch: %v", errSend)
+	}
+
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 1 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestStaleWhileRevalidate -count 1 ./clientcredentials
+func TestStaleWhileRevalidate(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 30)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return strings.HasPrefix(t, "token-") })
+	defer srv.Close()
+
+	var staleRemain []time.Duration
+	revalidated := make(chan error, 10)
+
+	client := New(Options{
+		TokenURL:             ts.URL,
+		ClientID:             "clientID",
+		ClientSecret:         "clientSecret",
+		SoftExpireInSeconds:  10,
+		TimeSource:           clock.Now,
+		StaleWhileRevalidate: true,
+		OnStaleToken:         func(remain time.Duration) { staleRemain = append(staleRemain, remain) },
+		OnRevalidate:         func(err error) { revalidated <- err },
+	})
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// token server goes down while token is stale: 5 seconds to hard expire
+
+	down.Store(true)
+	clock.Advance(25 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("stale token should have been served: %v", errSend)
+	}
+	if len(staleRemain) != 1 || staleRemain[0] != 5*time.Second {
+		t.Errorf("unexpected stale token hook calls: %v", staleRemain)
+	}
+	if errRevalidate := waitRevalidate(t, revalidated); errRevalidate == nil {
+		t.Errorf("revalidation should have failed")
+	}
+
+	// token hard expired: request fails
+
+	clock.Advance(6 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend == nil {
+		t.Errorf("hard expired token should not be served")
+	}
+	if len(staleRemain) != 1 {
+		t.Errorf("unexpected stale token hook calls: %v", staleRemain)
+	}
+
+	// token server recovers
+
+	down.Store(false)
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 4 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestStaleWhileRevalidateThrottle -count 1 ./clientcredentials
+func TestStaleWhileRevalidateThrottle(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 30)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return strings.HasPrefix(t, "token-") })
+	defer srv.Close()
+
+	revalidated := make(chan error, 100)
+
+	client := New(Options{
+		TokenURL:             ts.URL,
+		ClientID:             "clientID",
+		ClientSecret:         "clientSecret",
+		SoftExpireInSeconds:  10,
+		TimeSource:           clock.Now,
+		StaleWhileRevalidate: true,
+		RevalidateMinBackoff: time.Second,
+		RevalidateMaxBackoff: 2 * time.Second,
+		OnRevalidate:         func(err error) { revalidated <- err },
+	})
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+
+	// token server fails fast while token is stale
+
+	down.Store(true)
+	clock.Advance(20 * time.Second)
+
+	// sendStale sends requests served with the stale token, reporting
+	// whether they started a revalidation
+	sendStale := func(label string) bool {
+		t.Helper()
+		var started bool
+		for range 10 {
+			if _, errSend := send(client, srv.URL); errSend != nil {
+				t.Errorf("%s: stale token should have been served: %v", label, errSend)
+			}
+			select {
+			case err := <-revalidated:
+				if err == nil {
+					return true
+				}
+				started = true
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		return started
+	}
+
+	if !sendStale("first failure") {
+		t.Errorf("first stale request should revalidate")
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("revalidation not throttled: token server access count: %d", tokenServerStat.count)
+	}
+
+	// backoff 1s
+
+	clock.Advance(time.Second)
+	if !sendStale("after 1s") {
+		t.Errorf("revalidation should be allowed after 1s")
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// backoff 2s
+
+	clock.Advance(time.Second)
+	if sendStale("after 1s of 2s") {
+		t.Errorf("revalidation should be throttled for 2s")
+	}
+	clock.Advance(time.Second)
+	if !sendStale("after 2s") {
+		t.Errorf("revalidation should be allowed after 2s")
+	}
+	if tokenServerStat.count != 4 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// token server is back: renewal after backoff (capped at 2s)
+
+	down.Store(false)
+	clock.Advance(2 * time.Second)
+	if !sendStale("recovered") {
+		t.Errorf("revalidation should be allowed after max backoff")
+	}
+	if tokenServerStat.count != 5 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestStaleWhileRevalidateRenews -count 1 ./clientcredentials
+func TestStaleWhileRevalidateRenews(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 30)
+	defer ts.Close()
+
+	var mutex sync.Mutex
+	var seen []string
+
+	srv := newServer(&serverStat, func(t string) bool {
+		mutex.Lock()
+		seen = append(seen, t)
+		mutex.Unlock()
+		return true
+	})
+	defer srv.Close()
+
+	revalidated := make(chan error, 10)
+
+	client := New(Options{
+		TokenURL:             ts.URL,
+		ClientID:             "clientID",
+		ClientSecret:         "clientSecret",
+		SoftExpireInSeconds:  10,
+		TimeSource:           clock.Now,
+		StaleWhileRevalidate: true,
+		OnRevalidate:         func(err error) { revalidated <- err },
+	})
+
+	send(client, srv.URL)
+
+	clock.Advance(25 * time.Second)
+
+	send(client, srv.URL) // stale token-1, renewed in background
+
+	if errRevalidate := waitRevalidate(t, revalidated); errRevalidate != nil {
+		t.Errorf("revalidate: %v", errRevalidate)
+	}
+
+	send(client, srv.URL) // fresh token-2
+
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := []string{"token-1", "token-1", "token-2"}
+	if !slices.Equal(seen, expected) {
+		t.Errorf("unexpected tokens: expected=%v got=%v", expected, seen)
+	}
+}
+
+// go test -run TestStaleWithoutRevalidate -count 1 ./clientcredentials
+func TestStaleWithoutRevalidate(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	var down atomic.Bool
+
+	ts := newTokenServerToggle(&tokenServerStat, &down, 30)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return strings.HasPrefix(t, "token-") })
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:            ts.URL,
+		ClientID:            "clientID",
+		ClientSecret:        "clientSecret",
+		SoftExpireInSeconds: 10,
+		TimeSource:          clock.Now,
+	})
+
+	send(client, srv.URL)
+
+	down.Store(true)
+	clock.Advance(25 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend == nil {
+		t.Errorf("stale token should not be served by default")
+	}
+}
+
+func waitRevalidate(t *testing.T, revalidated chan error) error {
+	t.Helper()
+	select {
+	case err := <-revalidated:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for revalidation")
+	}
+	return nil
+}
+
 type sendResult struct {
 	body   string
 	status int
 }
 
 func send(client *Client, serverURL string) (sendResult, error) {
+	return sendWithContext(context.TODO(), client, serverURL)
+}
+
+func sendWithContext(ctx context.Context, client *Client, serverURL string) (sendResult, error) {
 
 	var result sendResult
 
-	req, errReq := http.NewRequestWithContext(context.TODO(), "GET", serverURL, nil)
+	req, errReq := http.NewRequestWithContext(ctx, "GET", serverURL, nil)
 	if errReq != nil {
 		return result, fmt.Errorf("request: %v", errReq)
 	}
 
 	resp, errDo := client.Do(req)
 	if errDo != nil {
-		return result, fmt.Errorf("do: %v", errDo)
+		return result, fmt.Errorf("do: %w", errDo)
 	}
 	defer resp.Body.Close()
 
@@ -685,6 +1453,36 @@ func newTokenServer(serverInfo *serverStat, clientID, clientSecret, token string
 	}))
 }
 
+// newTokenServerToggle creates a token server that issues token-1, token-2
+// and so on, failing while down is set.
+func newTokenServerToggle(serverInfo *serverStat, down *atomic.Bool, expireIn int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serverInfo.mutex.Lock()
+		serverInfo.count++
+		n := serverInfo.count
+		serverInfo.mutex.Unlock()
+		if down.Load() {
+			httpJSON(w, `{"error":"temporarily_unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"token-%d","expires_in":%d}`, n, expireIn), http.StatusOK)
+	}))
+}
+
+// newTokenServerStalled creates a token server that holds every request
+// until release is closed or the client goes away.
+func newTokenServerStalled(serverInfo *serverStat, token string, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverInfo.inc()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s"}`, token), http.StatusOK)
+	}))
+}
+
 func newTokenServerBroken(serverInfo *serverStat) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		serverInfo.inc()
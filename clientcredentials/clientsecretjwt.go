@@ -0,0 +1,43 @@
+package clientcredentials
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// clientSecretAssertion creates a fresh client assertion signed with HMAC
+// keyed by the client secret, for client_secret_jwt.
+func (c *Client) clientSecretAssertion() (string, error) {
+	if c.options.ClientSecret == "" {
+		return "", errors.New("client_secret_jwt requires option ClientSecret")
+	}
+
+	alg := c.options.AssertionAlgorithm
+	if alg == "" {
+		alg = "HS256"
+	}
+
+	var h func() hash.Hash
+	switch alg {
+	case "HS256":
+		h = sha256.New
+	case "HS384":
+		h = sha512.New384
+	case "HS512":
+		h = sha512.New
+	default:
+		return "", fmt.Errorf("unsupported client_secret_jwt signing algorithm: %s", alg)
+	}
+
+	secret := []byte(c.options.ClientSecret)
+
+	return c.clientAssertion(alg, func(signingInput []byte) ([]byte, error) {
+		mac := hmac.New(h, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	})
+}
@@ -0,0 +1,141 @@
+package clientcredentials
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"testing"
+	"time"
+)
+
+// go test -run TestClientSecretJWT -count 1 ./clientcredentials
+func TestClientSecretJWT(t *testing.T) {
+
+	for _, alg := range []string{"", "HS256", "HS384", "HS512"} {
+		t.Run(alg, func(t *testing.T) {
+
+			clock := newFakeClock()
+
+			tokenServerStat := assertionStat{}
+			ts := newTokenServerAssertion(&tokenServerStat, "clientID", "", []byte("clientSecret"))
+			defer ts.Close()
+
+			client := New(Options{
+				TokenURL:           ts.URL,
+				ClientID:           "clientID",
+				ClientSecret:       "clientSecret",
+				AuthMethod:         AuthMethodClientSecretJWT,
+				AssertionAlgorithm: alg,
+				AssertionLifetime:  30 * time.Second,
+				TimeSource:         clock.Now,
+			})
+
+			for range 2 {
+				if _, err := client.fetchToken(t.Context()); err != nil {
+					t.Fatalf("fetch token: %v", err)
+				}
+			}
+
+			assertions := tokenServerStat.assertions()
+			if len(assertions) != 2 {
+				t.Fatalf("unexpected assertion count: %d", len(assertions))
+			}
+
+			header, claims := assertions[0].header, assertions[0].claims
+
+			expectedAlg := alg
+			if expectedAlg == "" {
+				expectedAlg = "HS256"
+			}
+			if header.Alg != expectedAlg {
+				t.Errorf("alg: expected=%s got=%s", expectedAlg, header.Alg)
+			}
+			if claims.Iss != "clientID" || claims.Sub != "clientID" || claims.Aud != ts.URL {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+			if claims.Exp-claims.Iat != 30 {
+				t.Errorf("unexpected lifetime: %d", claims.Exp-claims.Iat)
+			}
+			if claims.Jti == assertions[1].claims.Jti {
+				t.Errorf("jti must be unique per request: %s", claims.Jti)
+			}
+		})
+	}
+}
+
+// go test -run TestClientSecretJWTWrongSecret -count 1 ./clientcredentials
+func TestClientSecretJWTWrongSecret(t *testing.T) {
+
+	tokenServerStat := assertionStat{}
+	ts := newTokenServerAssertion(&tokenServerStat, "clientID", "", []byte("clientSecret"))
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "WRONG",
+		AuthMethod:   AuthMethodClientSecretJWT,
+	})
+
+	if _, err := client.fetchToken(t.Context()); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("expected invalid_client, got: %v", err)
+	}
+}
+
+// go test -run TestClientSecretJWTErrors -count 1 ./clientcredentials
+func TestClientSecretJWTErrors(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		options Options
+	}{
+		{"missing secret", Options{}},
+		{"bad algorithm", Options{ClientSecret: "clientSecret", AssertionAlgorithm: "RS256"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokenServerStat := assertionStat{}
+			ts := newTokenServerAssertion(&tokenServerStat, "clientID", "", []byte("clientSecret"))
+			defer ts.Close()
+
+			options := tc.options
+			options.TokenURL = ts.URL
+			options.ClientID = "clientID"
+			options.AuthMethod = AuthMethodClientSecretJWT
+
+			client := New(options)
+
+			if _, err := client.fetchToken(t.Context()); err == nil {
+				t.Errorf("expected error")
+			}
+			if tokenServerStat.count != 0 {
+				t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+			}
+		})
+	}
+}
+
+// verifyHMAC verifies the HS256/HS384/HS512 signature.
+func verifyHMAC(alg string, secret, signingInput, sig []byte) error {
+	var h func() hash.Hash
+	switch alg {
+	case "HS256":
+		h = sha256.New
+	case "HS384":
+		h = sha512.New384
+	case "HS512":
+		h = sha512.New
+	default:
+		return fmt.Errorf("unsupported alg: %s", alg)
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(signingInput)
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return errors.New("bad HMAC signature")
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package clientcredentials
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// dpopHeader is the JOSE header of the DPoP proof.
+type dpopHeader struct {
+	Typ string         `json:"typ"`
+	Alg string         `json:"alg"`
+	JWK map[string]any `json:"jwk"`
+}
+
+// dpopClaims holds the DPoP proof claims from RFC 9449 section 4.2.
+type dpopClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Ath   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// dpopNonces caches the latest DPoP-Nonce from every server.
+type dpopNonces struct {
+	mutex  sync.Mutex
+	nonces map[string]string // origin => nonce
+}
+
+func (n *dpopNonces) get(u *url.URL) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.nonces[origin(u)]
+}
+
+// save records the nonce provided by the server response, if any.
+// It reports whether the nonce has changed.
+func (n *dpopNonces) save(u *url.URL, resp *http.Response) bool {
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return false
+	}
+	key := origin(u)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.nonces == nil {
+		n.nonces = map[string]string{}
+	}
+	changed := n.nonces[key] != nonce
+	n.nonces[key] = nonce
+	return changed
+}
+
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// dpopKey gets the DPoP key, generating a P-256 key if option DPoPKey is
+// undefined.
+func (c *Client) dpopKey() (crypto.Signer, error) {
+	if c.options.DPoPKey != nil {
+		return c.options.DPoPKey, nil
+	}
+	c.dpopKeyOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		c.generatedDPoPKey, c.generatedDPoPKeyErr = key, err
+	})
+	return c.generatedDPoPKey, c.generatedDPoPKeyErr
+}
+
+// dpopProof creates a fresh DPoP proof for the request method and URL.
+// If accessToken is not empty, the proof is bound to it with claim ath.
+func (c *Client) dpopProof(method string, u *url.URL, accessToken string) (string, error) {
+	key, errKey := c.dpopKey()
+	if errKey != nil {
+		return "", errKey
+	}
+
+	alg, errAlg := signingAlgorithm(key, c.options.DPoPAlgorithm)
+	if errAlg != nil {
+		return "", errAlg
+	}
+
+	jwk, errJWK := publicJWK(key.Public())
+	if errJWK != nil {
+		return "", errJWK
+	}
+
+	// htu excludes query and fragment, keeping the escaped path
+	htu := *u
+	htu.User = nil
+	htu.ForceQuery = false
+	htu.RawQuery = ""
+	htu.Fragment = ""
+	htu.RawFragment = ""
+
+	claims := dpopClaims{
+		Jti:   c.options.AssertionJTI(),
+		Htm:   method,
+		Htu:   htu.String(),
+		Iat:   c.options.TimeSource().Unix(),
+		Nonce: c.dpopNonces.get(u),
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims.Ath = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	header := dpopHeader{Typ: "dpop+jwt", Alg: alg, JWK: jwk}
+
+	return encodeJWT(header, claims, func(signingInput []byte) ([]byte, error) {
+		return signJWS(key, alg, signingInput)
+	})
+}
+
+// publicJWK encodes the public key as JWK (RFC 7517).
+func publicJWK(pub crypto.PublicKey) (map[string]any, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]any{
+			"kty": "RSA",
+			"n":   b64(k.N.Bytes()),
+			"e":   b64(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		ecdh, errECDH := k.ECDH()
+		if errECDH != nil {
+			return nil, errECDH
+		}
+		point := ecdh.Bytes() // uncompressed: 0x04 || X || Y
+		return map[string]any{
+			"kty": "EC",
+			"crv": k.Curve.Params().Name,
+			"x":   b64(point[1 : 1+size]),
+			"y":   b64(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]any{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(k),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported DPoP key type: %T", pub)
+}
+
+// doTokenRequest sends the token request. In DPoP mode, it attaches the
+// DPoP proof, and retries once if the token server demands a new nonce.
+func (c *Client) doTokenRequest(req *http.Request) (*http.Response, error) {
+	if !c.options.DPoP {
+		return c.options.HTTPClient.Do(req)
+	}
+
+	return c.sendWithDPoP(req, "", c.isTokenNonceError, c.options.HTTPClient.Do)
+}
+
+// sendWithDPoP attaches the DPoP proof and sends the request. If the
+// server refuses the proof for lack of a fresh nonce, the request is
+// retried once with the nonce provided by the server.
+func (c *Client) sendWithDPoP(req *http.Request, accessToken string,
+	isNonceError func(*http.Response) bool,
+	do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+
+	proof, errProof := c.dpopProof(req.Method, req.URL, accessToken)
+	if errProof != nil {
+		closeBody(req)
+		return nil, fmt.Errorf("dpop proof: %w", errProof)
+	}
+	req.Header.Set("DPoP", proof)
+
+	resp, errDo := do(req)
+	if errDo != nil {
+		return resp, errDo
+	}
+
+	if !c.dpopNonces.save(req.URL, resp) || !isNonceError(resp) {
+		return resp, nil
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		c.debugf("dpop nonce required: request body is not replayable, not retrying")
+		return resp, nil
+	}
+
+	retry, errRewind := rewind(req)
+	if errRewind != nil {
+		c.errorf("dpop nonce required: request body rewind error, not retrying: %v", errRewind)
+		return resp, nil
+	}
+
+	// discard refused response
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	c.debugf("dpop nonce required: retrying with new nonce")
+
+	proof, errProof = c.dpopProof(retry.Method, retry.URL, accessToken)
+	if errProof != nil {
+		closeBody(retry)
+		return nil, fmt.Errorf("dpop proof: %w", errProof)
+	}
+	retry.Header.Set("DPoP", proof)
+
+	return do(retry)
+}
+
+// isTokenNonceError checks whether the token server demands a DPoP nonce,
+// with status 400 and error use_dpop_nonce (RFC 9449 section 8).
+// The response body is left intact for the reader.
+func (c *Client) isTokenNonceError(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, c.options.TokenResponseBodyLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+	return newTokenError(resp.StatusCode, buf, nil).Code == "use_dpop_nonce"
+}
+
+// isResourceNonceError checks whether the resource server demands a DPoP
+// nonce, with status 401 and WWW-Authenticate error use_dpop_nonce
+// (RFC 9449 section 9).
+func isResourceNonceError(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized &&
+		strings.Contains(resp.Header.Get("WWW-Authenticate"), "use_dpop_nonce")
+}
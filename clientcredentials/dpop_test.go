@@ -0,0 +1,358 @@
+package clientcredentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// go test -run TestDPoP -count 1 ./clientcredentials
+func TestDPoP(t *testing.T) {
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	testCases := []struct {
+		name string
+		key  crypto.Signer
+		path string
+	}{
+		{"generated key", nil, "/path"},
+		{"ed25519", edKey, "/path"},
+		{"rsa", rsaKey, "/path"},
+		{"escaped path", nil, "/a%2Fb/c"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			tokenServer := newDPoPServer("token-nonce")
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proof, err := tokenServer.check(r, "")
+				if err != nil {
+					tokenServer.fail(w, err, http.StatusBadRequest, `{"error":"use_dpop_nonce"}`, "")
+					return
+				}
+				tokenServer.record(proof)
+				httpJSON(w, `{"access_token":"abc","token_type":"DPoP"}`, http.StatusOK)
+			}))
+			defer ts.Close()
+
+			resourceServer := newDPoPServer("resource-nonce")
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "DPoP abc" {
+					httpJSON(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+					return
+				}
+				proof, err := resourceServer.check(r, "abc")
+				if err != nil {
+					resourceServer.fail(w, err, http.StatusUnauthorized, `{"error":"use_dpop_nonce"}`,
+						`DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`)
+					return
+				}
+				resourceServer.record(proof)
+				httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+			}))
+			defer srv.Close()
+
+			client := New(Options{
+				TokenURL:     ts.URL,
+				ClientID:     "clientID",
+				ClientSecret: "clientSecret",
+				DPoP:         true,
+				DPoPKey:      tc.key,
+			})
+
+			for range 2 {
+				result, errSend := send(client, srv.URL+tc.path+"?query=1")
+				if errSend != nil {
+					t.Fatalf("send: %v", errSend)
+				}
+				if result.status != 200 {
+					t.Fatalf("unexpected status: %d", result.status)
+				}
+			}
+
+			// token server: first attempt without nonce, then with nonce
+
+			if tokenServer.count != 2 {
+				t.Errorf("unexpected token server access count: %d", tokenServer.count)
+			}
+			if len(tokenServer.accepted) != 1 {
+				t.Fatalf("unexpected token proofs: %d", len(tokenServer.accepted))
+			}
+			tokenProof := tokenServer.accepted[0]
+			if tokenProof.claims.Htm != "POST" || tokenProof.claims.Htu != ts.URL || tokenProof.claims.Ath != "" {
+				t.Errorf("unexpected token proof claims: %+v", tokenProof.claims)
+			}
+
+			// resource server: first request retried with nonce, second request uses cached nonce
+
+			if resourceServer.count != 3 {
+				t.Errorf("unexpected server access count: %d", resourceServer.count)
+			}
+			if len(resourceServer.accepted) != 2 {
+				t.Fatalf("unexpected resource proofs: %d", len(resourceServer.accepted))
+			}
+			resourceProof := resourceServer.accepted[0]
+			if resourceProof.claims.Htm != "GET" || resourceProof.claims.Htu != srv.URL+tc.path {
+				t.Errorf("unexpected resource proof claims: %+v", resourceProof.claims)
+			}
+			if resourceProof.claims.Jti == resourceServer.accepted[1].claims.Jti {
+				t.Errorf("jti must be unique per proof")
+			}
+
+			// same key for all proofs
+
+			if fmt.Sprint(tokenProof.header.JWK) != fmt.Sprint(resourceProof.header.JWK) {
+				t.Errorf("proofs signed with distinct keys")
+			}
+		})
+	}
+}
+
+// go test -run TestDPoPTransport -count 1 ./clientcredentials
+func TestDPoPTransport(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("DPoP") == "" {
+			httpJSON(w, `{"error":"invalid_dpop_proof"}`, http.StatusBadRequest)
+			return
+		}
+		httpJSON(w, `{"access_token":"abc","token_type":"DPoP"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	resourceServer := newDPoPServer("resource-nonce")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proof, err := resourceServer.check(r, "abc")
+		if err != nil {
+			resourceServer.fail(w, err, http.StatusUnauthorized, `{"error":"use_dpop_nonce"}`,
+				`DPoP error="use_dpop_nonce"`)
+			return
+		}
+		resourceServer.record(proof)
+		w.WriteHeader(http.StatusOK)
+		r.Body.Close()
+	}))
+	defer srv.Close()
+
+	httpClient := NewHTTPClient(Options{
+		TokenURL: ts.URL,
+		DPoP:     true,
+	}, nil)
+
+	resp, errPost := httpClient.Post(srv.URL, "text/plain", &onlyReader{r: strings.NewReader("body")})
+	if errPost != nil {
+		t.Fatalf("post: %v", errPost)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	if resourceServer.count != 2 {
+		t.Errorf("unexpected server access count: %d", resourceServer.count)
+	}
+}
+
+// go test -run TestDPoPBearerToken -count 1 ./clientcredentials
+func TestDPoPBearerToken(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpJSON(w, `{"access_token":"abc","token_type":"Bearer"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL: ts.URL,
+		DPoP:     true,
+	})
+
+	if _, err := send(client, "http://localhost"); !errors.Is(err, ErrTokenTypeMismatch) {
+		t.Errorf("expected token type mismatch, got: %v", err)
+	}
+}
+
+// go test -run TestDPoPNonceNotBadToken -count 1 ./clientcredentials
+func TestDPoPNonceNotBadToken(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tokenServerStat.inc()
+		httpJSON(w, `{"access_token":"abc","token_type":"DPoP"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	// resource server keeps demanding the same nonce, then refusing it
+
+	resourceServer := newDPoPServer("resource-nonce")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resourceServer.check(r, "abc")
+		resourceServer.fail(w, errNonce, http.StatusUnauthorized, `{"error":"use_dpop_nonce"}`,
+			`DPoP error="use_dpop_nonce"`)
+	}))
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:      ts.URL,
+		DPoP:          true,
+		RetryBadToken: 1,
+	})
+
+	for range 2 {
+		result, _ := send(client, srv.URL)
+		if result.status != 401 {
+			t.Errorf("unexpected status: %d", result.status)
+		}
+	}
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("nonce error expired the token: token server access count: %d", tokenServerStat.count)
+	}
+}
+
+type dpopProof struct {
+	header dpopHeader
+	claims dpopClaims
+}
+
+// dpopServer verifies DPoP proofs, demanding nonce.
+type dpopServer struct {
+	mutex    sync.Mutex
+	nonce    string
+	count    int
+	accepted []dpopProof
+}
+
+func newDPoPServer(nonce string) *dpopServer {
+	return &dpopServer{nonce: nonce}
+}
+
+var errNonce = errors.New("use_dpop_nonce")
+
+// check verifies the DPoP proof of the request.
+func (s *dpopServer) check(r *http.Request, accessToken string) (dpopProof, error) {
+	s.mutex.Lock()
+	s.count++
+	s.mutex.Unlock()
+
+	proof, err := verifyDPoP(r.Header.Get("DPoP"))
+	if err != nil {
+		return proof, err
+	}
+
+	scheme := "http://"
+	htu := scheme + r.Host + r.URL.EscapedPath()
+	if proof.claims.Htm != r.Method || strings.TrimSuffix(proof.claims.Htu, "/") != strings.TrimSuffix(htu, "/") {
+		return proof, fmt.Errorf("bad htm/htu: %s %s", proof.claims.Htm, proof.claims.Htu)
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if proof.claims.Ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return proof, errors.New("bad ath")
+		}
+	}
+
+	if proof.claims.Nonce != s.nonce {
+		return proof, errNonce
+	}
+
+	return proof, nil
+}
+
+func (s *dpopServer) record(proof dpopProof) {
+	s.mutex.Lock()
+	s.accepted = append(s.accepted, proof)
+	s.mutex.Unlock()
+}
+
+// fail refuses the request, providing the nonce on nonce errors.
+func (s *dpopServer) fail(w http.ResponseWriter, err error, nonceStatus int, nonceBody, wwwAuthenticate string) {
+	if !errors.Is(err, errNonce) {
+		httpJSON(w, `{"error":"invalid_dpop_proof"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("DPoP-Nonce", s.nonce)
+	if wwwAuthenticate != "" {
+		w.Header().Set("WWW-Authenticate", wwwAuthenticate)
+	}
+	httpJSON(w, nonceBody, nonceStatus)
+}
+
+// verifyDPoP verifies the DPoP proof signature with its embedded JWK.
+func verifyDPoP(jwt string) (dpopProof, error) {
+	var proof dpopProof
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return proof, fmt.Errorf("bad dpop proof: %d parts", len(parts))
+	}
+
+	h, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	c, _ := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err := json.Unmarshal(h, &proof.header); err != nil {
+		return proof, err
+	}
+	if err := json.Unmarshal(c, &proof.claims); err != nil {
+		return proof, err
+	}
+
+	if proof.header.Typ != "dpop+jwt" {
+		return proof, fmt.Errorf("bad typ: %s", proof.header.Typ)
+	}
+
+	key, errKey := jwkPublicKey(proof.header.JWK)
+	if errKey != nil {
+		return proof, errKey
+	}
+
+	if _, err := verifyJWT(jwt, key); err != nil {
+		return proof, err
+	}
+
+	return proof, nil
+}
+
+// jwkPublicKey decodes the public JWK.
+func jwkPublicKey(jwk map[string]any) (any, error) {
+	field := func(name string) []byte {
+		s, _ := jwk[name].(string)
+		buf, _ := base64.RawURLEncoding.DecodeString(s)
+		return buf
+	}
+	switch jwk["kty"] {
+	case "EC":
+		curves := map[any]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		return &ecdsa.PublicKey{
+			Curve: curves[jwk["crv"]],
+			X:     new(big.Int).SetBytes(field("x")),
+			Y:     new(big.Int).SetBytes(field("y")),
+		}, nil
+	case "OKP":
+		return ed25519.PublicKey(field("x")), nil
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(field("n")),
+			E: int(new(big.Int).SetBytes(field("e")).Int64()),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported jwk: %v", jwk)
+}
@@ -0,0 +1,93 @@
+package clientcredentials
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type for JWT
+// client assertions, defined by RFC 7523.
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// jwtHeader is the JOSE header of the client assertion.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// jwtClaims holds the client assertion claims from RFC 7523 section 3.
+type jwtClaims struct {
+	Iss string `json:"iss"`
+	Sub string `json:"sub"`
+	Aud string `json:"aud"`
+	Jti string `json:"jti"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+// assertion creates the client assertion for the JWT based method.
+func (c *Client) assertion(method AuthMethod) (string, error) {
+	if method == AuthMethodClientSecretJWT {
+		return c.clientSecretAssertion()
+	}
+	return c.privateKeyAssertion()
+}
+
+// clientAssertion creates a fresh client assertion, shared by the JWT
+// based client authentication methods, signed with sign as algorithm alg.
+func (c *Client) clientAssertion(alg string, sign func(signingInput []byte) ([]byte, error)) (string, error) {
+	header := jwtHeader{Alg: alg, Typ: "JWT", Kid: c.options.AssertionKeyID}
+	return encodeJWT(header, c.assertionClaims(), sign)
+}
+
+// assertionClaims creates the client assertion claims.
+func (c *Client) assertionClaims() jwtClaims {
+	now := c.options.TimeSource()
+
+	audience := c.options.AssertionAudience
+	if audience == "" {
+		audience = c.options.TokenURL
+	}
+
+	return jwtClaims{
+		Iss: c.options.ClientID,
+		Sub: c.options.ClientID,
+		Aud: audience,
+		Jti: c.options.AssertionJTI(),
+		Iat: now.Unix(),
+		Exp: now.Add(c.options.AssertionLifetime).Unix(),
+	}
+}
+
+// encodeJWT builds a compact JWS, signing "<header>.<claims>" with sign.
+func encodeJWT(header, claims any, sign func(signingInput []byte) ([]byte, error)) (string, error) {
+	h, errHeader := json.Marshal(header)
+	if errHeader != nil {
+		return "", errHeader
+	}
+	c, errClaims := json.Marshal(claims)
+	if errClaims != nil {
+		return "", errClaims
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." +
+		base64.RawURLEncoding.EncodeToString(c)
+
+	sig, errSign := sign([]byte(signingInput))
+	if errSign != nil {
+		return "", errSign
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// DefaultAssertionJTI is used as default function when option AssertionJTI
+// is left undefined. It generates 128 random bits as hex.
+func DefaultAssertionJTI() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
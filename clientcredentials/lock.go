@@ -0,0 +1,97 @@
+package clientcredentials
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// lockReleaseTimeout bounds the unlock and the fenced put, which run
+// detached from the caller cancelation.
+const lockReleaseTimeout = 5 * time.Second
+
+// fetchTokenLocked retrieves new token guarded by the cache distributed
+// lock, so that only one process sharing the cache hits the token server.
+// While the lock is held by another process, it polls the cache for the
+// new token. If the lock holder dies, the lock expires and another
+// process takes over.
+func (c *Client) fetchTokenLocked(ctx context.Context, locker token.TokenCacheLocker) (token.Token, error) {
+
+	key := c.cacheKey(ctx)
+
+	// A valid cached token only counts as renewed by another process if
+	// it differs from the token seen before locking, since early renewal,
+	// like the background refresh, starts while the token is still valid.
+	seen, _ := c.cache.GetToken(ctx, key)
+
+	for {
+		lock, errLock := locker.TryLockToken(ctx, key)
+		if errors.Is(errLock, token.ErrLockUnsupported) {
+			return c.fetchTokenUnlocked(ctx)
+		}
+		if errLock != nil {
+			c.errorf("cache lock error: %v", errLock)
+			return c.fetchTokenUnlocked(ctx)
+		}
+
+		if lock != nil {
+			return c.fetchTokenWithLock(ctx, lock, seen)
+		}
+
+		c.debugf("cache lock held by another process, waiting for token")
+
+		select {
+		case <-ctx.Done():
+			return token.Token{}, ctx.Err()
+		case <-c.options.After(locker.LockPollInterval()):
+		}
+
+		if t, found := c.cachedToken(ctx); found && !sameToken(t, seen) {
+			return t, nil
+		}
+	}
+}
+
+// fetchTokenWithLock retrieves new token and saves into cache while
+// holding the lock. seen is the cached token before locking.
+func (c *Client) fetchTokenWithLock(ctx context.Context, lock token.TokenLock, seen token.Token) (token.Token, error) {
+
+	c.debugf("cache lock acquired: fence=%d", lock.Fence())
+
+	// A caller canceled during the fetch must still release the lock,
+	// otherwise the other processes would wait for the lock expiration.
+	release, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+	defer cancel()
+
+	defer func() {
+		if err := lock.Unlock(release); err != nil {
+			c.errorf("cache unlock error: %v", err)
+		}
+	}()
+
+	//
+	// the previous lock holder might have just saved a new token
+	//
+	if t, found := c.cachedToken(ctx); found && !sameToken(t, seen) {
+		return t, nil
+	}
+
+	newToken, errFetch := c.requestToken(ctx)
+	if errFetch != nil {
+		return token.Token{}, errFetch
+	}
+
+	c.debugf("saving new token: fence=%d", lock.Fence())
+	if err := lock.PutToken(release, newToken); err != nil {
+		c.errorf("cache fenced put error: %v", err)
+	}
+
+	return newToken, nil
+}
+
+// sameToken checks whether both tokens are the same issued token.
+func sameToken(a, b token.Token) bool {
+	return a.Value == b.Value && a.Deadline.Equal(b.Deadline)
+}
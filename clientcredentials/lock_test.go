@@ -0,0 +1,272 @@
+package clientcredentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/udhos/oauth2/cache/rediscache"
+)
+
+// go test -run TestDistributedLock -count 1 ./clientcredentials
+func TestDistributedLock(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerSlow(&tokenServerStat, "abc", 200*time.Millisecond)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	//
+	// each client stands for a distinct pod sharing the redis cache
+	//
+
+	var clients []*Client
+
+	for range 5 {
+		c, errCache := rediscache.New(rediscache.Options{
+			RedisString:      mr.Addr() + "::shared-key",
+			DistributedLock:  true,
+			LockPollInterval: 20 * time.Millisecond,
+		})
+		if errCache != nil {
+			t.Fatalf("cache: %v", errCache)
+		}
+		defer c.Close()
+
+		clients = append(clients, New(Options{
+			TokenURL:     ts.URL,
+			ClientID:     "clientID",
+			ClientSecret: "clientSecret",
+			Cache:        c,
+		}))
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		for range 10 {
+			wg.Go(func() {
+				if _, errSend := send(client, srv.URL); errSend != nil {
+					t.Errorf("send: %v", errSend)
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 50 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestDistributedLockHolderDies -count 1 ./clientcredentials
+func TestDistributedLockHolderDies(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, "clientID", "clientSecret", "abc", 60)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	lockTTL := 10 * time.Second
+
+	c, errCache := rediscache.New(rediscache.Options{
+		RedisString:      mr.Addr() + "::shared-key",
+		DistributedLock:  true,
+		LockTTL:          lockTTL,
+		LockPollInterval: 20 * time.Millisecond,
+	})
+	if errCache != nil {
+		t.Fatalf("cache: %v", errCache)
+	}
+	defer c.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Cache:        c,
+	})
+
+	//
+	// a dead pod holds the lock
+	//
+
+	dead, errLock := c.TryLockToken(context.TODO(), "")
+	if errLock != nil || dead == nil {
+		t.Fatalf("dead pod lock: lock=%v error=%v", dead, errLock)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, errSend := send(client, srv.URL)
+		done <- errSend
+	}()
+
+	select {
+	case errSend := <-done:
+		t.Fatalf("client did not wait for lock holder: %v", errSend)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if tokenServerStat.count != 0 {
+		t.Errorf("token fetched while lock held by another pod: %d", tokenServerStat.count)
+	}
+
+	//
+	// lock expires, client takes over
+	//
+
+	mr.FastForward(lockTTL)
+
+	select {
+	case errSend := <-done:
+		if errSend != nil {
+			t.Errorf("send: %v", errSend)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client did not take over expired lock")
+	}
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// newTokenServerSlow creates a token server that takes delay to issue the token.
+func newTokenServerSlow(serverInfo *serverStat, token string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serverInfo.inc()
+		time.Sleep(delay)
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s","expires_in":60}`, token), http.StatusOK)
+	}))
+}
+
+// go test -run TestDistributedLockCanceledHolder -count 1 ./clientcredentials
+func TestDistributedLockCanceledHolder(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	release := make(chan struct{})
+
+	ts := newTokenServerStalled(&tokenServerStat, "abc", release)
+	defer ts.Close()
+	defer close(release)
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	c, errCache := rediscache.New(rediscache.Options{
+		RedisString:     mr.Addr() + "::shared-key",
+		DistributedLock: true,
+	})
+	if errCache != nil {
+		t.Fatalf("cache: %v", errCache)
+	}
+	defer c.Close()
+
+	client := New(Options{
+		TokenURL:            ts.URL,
+		ClientID:            "clientID",
+		ClientSecret:        "clientSecret",
+		Cache:               c,
+		DisableSingleFlight: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, errSend := sendWithContext(ctx, client, srv.URL); errSend == nil {
+		t.Errorf("expected error from canceled send")
+	}
+
+	if mr.Exists("shared-key|lock") {
+		t.Errorf("canceled lock holder did not release the lock")
+	}
+}
+
+// go test -run TestDistributedLockBackgroundRefresh -count 1 ./clientcredentials
+func TestDistributedLockBackgroundRefresh(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+
+	var down atomic.Bool
+	ts := newTokenServerToggle(&tokenServerStat, &down, 60)
+	defer ts.Close()
+
+	c, errCache := rediscache.New(rediscache.Options{
+		RedisString:     mr.Addr() + "::shared-key",
+		DistributedLock: true,
+	})
+	if errCache != nil {
+		t.Fatalf("cache: %v", errCache)
+	}
+	defer c.Close()
+
+	client := New(Options{
+		TokenURL:                ts.URL,
+		ClientID:                "clientID",
+		ClientSecret:            "clientSecret",
+		Cache:                   c,
+		SoftExpireInSeconds:     10,
+		BackgroundRefresh:       true,
+		BackgroundRefreshJitter: 5 * time.Second,
+		TimeSource:              clock.Now,
+		After:                   clock.After,
+	})
+	defer client.Close(context.TODO())
+
+	// refresher fetches first token, then waits for early renewal,
+	// due at 60-10-jitter seconds, while the token is still valid
+
+	d := clock.waitTimer(t)
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if d <= 45*time.Second || d > 50*time.Second {
+		t.Errorf("unexpected renewal delay: %v", d)
+	}
+
+	clock.Advance(d)
+	clock.waitTimer(t)
+
+	if tokenServerStat.count != 2 {
+		t.Errorf("not renewed under lock: token server access count: %d", tokenServerStat.count)
+	}
+
+	got, errGet := c.Get()
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	if got.Value != "token-2" {
+		t.Errorf("unexpected cached token: %s", got.Value)
+	}
+}
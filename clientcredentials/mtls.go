@@ -0,0 +1,159 @@
+package clientcredentials
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// mtlsEnabled checks whether a client certificate is configured.
+func (o *Options) mtlsEnabled() bool {
+	return o.ClientCertificate != nil || o.GetClientCertificate != nil
+}
+
+// mtlsTLSConfig creates the TLS configuration presenting the client
+// certificate, based on option TLSConfig, or else on base, if any.
+func (o *Options) mtlsTLSConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	switch {
+	case o.TLSConfig != nil:
+		cfg = o.TLSConfig.Clone()
+	case base != nil:
+		cfg = base.Clone()
+	default:
+		cfg = &tls.Config{}
+	}
+
+	if o.GetClientCertificate != nil {
+		cfg.GetClientCertificate = o.GetClientCertificate
+	} else {
+		cert := o.ClientCertificate
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+
+	return cfg
+}
+
+// mtlsTransport creates a transport presenting the client certificate.
+func (o *Options) mtlsTransport() *http.Transport {
+	return o.mtlsTransportFrom(http.DefaultTransport.(*http.Transport))
+}
+
+// mtlsTransportFrom clones the transport, presenting the client certificate.
+func (o *Options) mtlsTransportFrom(base *http.Transport) *http.Transport {
+	t := base.Clone()
+	t.TLSClientConfig = o.mtlsTLSConfig(base.TLSClientConfig)
+	return t
+}
+
+// errMTLSHTTPClient reports option HTTPClient unable to present the client
+// certificate.
+var errMTLSHTTPClient = errors.New("mTLS: option HTTPClient must be *http.Client with *http.Transport in order to present the client certificate")
+
+// mtlsHTTPClient creates a copy of option HTTPClient presenting the client
+// certificate. It fails for HTTPClient other than *http.Client with nil
+// Transport or *http.Transport, since the certificate cannot be added to it.
+func (o *Options) mtlsHTTPClient() (*http.Client, error) {
+	hc, isClient := o.HTTPClient.(*http.Client)
+	if !isClient {
+		return nil, errMTLSHTTPClient
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, isTransport := base.(*http.Transport)
+	if !isTransport {
+		return nil, errMTLSHTTPClient
+	}
+	clone := *hc
+	clone.Transport = o.mtlsTransportFrom(t)
+	return &clone, nil
+}
+
+// tokenURL gets the token endpoint. In mTLS mode, the mtls_endpoint_aliases
+// token_endpoint takes precedence over TokenURL, as defined by RFC 8705.
+func (c *Client) tokenURL() string {
+	if c.options.mtlsEnabled() {
+		if alias := c.options.MTLSEndpointAliases["token_endpoint"]; alias != "" {
+			return alias
+		}
+	}
+	return c.options.TokenURL
+}
+
+// CertificateReloader loads a client certificate from a PEM pair of files,
+// reloading it whenever either file is modified. Use its method
+// GetClientCertificate for option GetClientCertificate, in order to rotate
+// the client certificate without restarting the process.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertificateReloader loads the client certificate from certFile and
+// keyFile.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate returns the current client certificate, reloading
+// it if the files have been modified. If the reload fails, for instance
+// because the files are being rewritten, the previous certificate is kept.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if cert, err := r.reloadLocked(); err == nil {
+		return cert, nil
+	}
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) reload() (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *CertificateReloader) reloadLocked() (*tls.Certificate, error) {
+	certInfo, errCert := os.Stat(r.certFile)
+	if errCert != nil {
+		return nil, errCert
+	}
+	keyInfo, errKey := os.Stat(r.keyFile)
+	if errKey != nil {
+		return nil, errKey
+	}
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return r.cert, nil // unchanged
+	}
+
+	cert, errLoad := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if errLoad != nil {
+		return nil, errLoad
+	}
+
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+
+	return r.cert, nil
+}
@@ -0,0 +1,345 @@
+package clientcredentials
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// go test -run TestMTLS -count 1 ./clientcredentials
+func TestMTLS(t *testing.T) {
+
+	ca := newTestCA(t)
+	clientCert := ca.issue(t, "client-1")
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTLSServer(ca, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenServerStat.inc()
+		r.ParseForm()
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "client-1" {
+			httpJSON(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if formParam(r, "client_id") != "clientID" || formParam(r, "client_secret") != "" ||
+			r.Header.Get("Authorization") != "" {
+			httpJSON(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		httpJSON(w, `{"access_token":"bound-token"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	srv := newTLSServer(ca, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverStat.inc()
+		if len(r.TLS.PeerCertificates) == 0 || r.Header.Get("Authorization") != "Bearer bound-token" {
+			httpJSON(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	roots.AddCert(srv.Certificate())
+
+	userTransport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	userHTTPClient := &http.Client{Transport: userTransport}
+
+	testCases := []struct {
+		name       string
+		authMethod AuthMethod
+		tlsConfig  *tls.Config
+		httpClient HTTPDoer
+	}{
+		{"explicit auth method", AuthMethodTLSClientAuth, &tls.Config{RootCAs: roots}, nil},
+		{"default auth method", "", &tls.Config{RootCAs: roots}, nil},
+		{"caller http client", "", nil, userHTTPClient},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			tokenCount := tokenServerStat.count
+			serverCount := serverStat.count
+
+			options := Options{
+				TokenURL:          "https://localhost:1/not-mtls-token-endpoint",
+				ClientID:          "clientID",
+				ClientSecret:      "must-not-be-sent",
+				AuthMethod:        tc.authMethod,
+				ClientCertificate: &clientCert,
+				TLSConfig:         tc.tlsConfig,
+				HTTPClient:        tc.httpClient,
+				MTLSEndpointAliases: map[string]string{
+					"token_endpoint": ts.URL,
+				},
+			}
+
+			//
+			// Client.Do
+			//
+
+			client := New(options)
+
+			result, errSend := send(client, srv.URL)
+			if errSend != nil {
+				t.Fatalf("send: %v", errSend)
+			}
+			if result.status != 200 {
+				t.Errorf("unexpected status: %d", result.status)
+			}
+
+			//
+			// Transport
+			//
+
+			httpClient := NewHTTPClient(options, nil)
+
+			resp, errGet := httpClient.Get(srv.URL)
+			if errGet != nil {
+				t.Fatalf("get: %v", errGet)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("unexpected transport status: %d", resp.StatusCode)
+			}
+
+			if n := tokenServerStat.count - tokenCount; n != 2 {
+				t.Errorf("unexpected token server access count: %d", n)
+			}
+			if n := serverStat.count - serverCount; n != 2 {
+				t.Errorf("unexpected server access count: %d", n)
+			}
+		})
+	}
+
+	if userHTTPClient.Transport != userTransport || userTransport.TLSClientConfig.GetClientCertificate != nil {
+		t.Errorf("caller http client was modified")
+	}
+}
+
+// go test -run TestMTLSUnsupportedHTTPClient -count 1 ./clientcredentials
+func TestMTLSUnsupportedHTTPClient(t *testing.T) {
+
+	ca := newTestCA(t)
+	clientCert := ca.issue(t, "client-1")
+
+	tokenServerStat := serverStat{}
+
+	ts := newTLSServer(ca, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tokenServerStat.inc()
+		httpJSON(w, `{"access_token":"abc"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:          ts.URL,
+		ClientID:          "clientID",
+		ClientCertificate: &clientCert,
+		HTTPClient:        &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)},
+	})
+
+	if _, err := send(client, "http://localhost"); !errors.Is(err, errMTLSHTTPClient) {
+		t.Errorf("expected mTLS http client error, got: %v", err)
+	}
+	if tokenServerStat.count != 0 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// go test -run TestMTLSWithoutCertificate -count 1 ./clientcredentials
+func TestMTLSWithoutCertificate(t *testing.T) {
+
+	ca := newTestCA(t)
+
+	tokenServerStat := serverStat{}
+
+	ts := newTLSServer(ca, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tokenServerStat.inc()
+		httpJSON(w, `{"access_token":"abc"}`, http.StatusOK)
+	}))
+	ts.Config.ErrorLog = discardLogger()
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:   ts.URL,
+		ClientID:   "clientID",
+		AuthMethod: AuthMethodTLSClientAuth,
+		HTTPClient: ts.Client(), // trusts the server, but presents no certificate
+	})
+
+	if _, err := client.fetchToken(t.Context()); err == nil {
+		t.Errorf("expected handshake error")
+	}
+	if tokenServerStat.count != 0 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestCertificateReloader -count 1 ./clientcredentials
+func TestCertificateReloader(t *testing.T) {
+
+	ca := newTestCA(t)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	ca.writePair(t, "client-1", certFile, keyFile, time.Now().Add(-time.Hour))
+
+	r, errReloader := NewCertificateReloader(certFile, keyFile)
+	if errReloader != nil {
+		t.Fatalf("reloader: %v", errReloader)
+	}
+
+	if name := commonName(t, r); name != "client-1" {
+		t.Errorf("unexpected certificate: %s", name)
+	}
+
+	// rotation
+
+	ca.writePair(t, "client-2", certFile, keyFile, time.Now())
+
+	if name := commonName(t, r); name != "client-2" {
+		t.Errorf("certificate not reloaded: %s", name)
+	}
+
+	// broken files keep previous certificate
+
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	os.Chtimes(certFile, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+
+	if name := commonName(t, r); name != "client-2" {
+		t.Errorf("previous certificate not kept: %s", name)
+	}
+
+	if _, err := NewCertificateReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func commonName(t *testing.T, r *CertificateReloader) string {
+	t.Helper()
+	cert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("get client certificate: %v", err)
+	}
+	leaf, errParse := x509.ParseCertificate(cert.Certificate[0])
+	if errParse != nil {
+		t.Fatalf("parse certificate: %v", errParse)
+	}
+	return leaf.Subject.CommonName
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue creates a client certificate signed by the CA.
+func (ca *testCA) issue(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issuePEM(t, commonName)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	return cert
+}
+
+func (ca *testCA) issuePEM(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writePair writes a client certificate PEM pair with modification time.
+func (ca *testCA) writePair(t *testing.T, commonName, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	certPEM, keyPEM := ca.issuePEM(t, commonName)
+	for file, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+}
+
+// newTLSServer creates a TLS server that requires client certificates
+// issued by the CA.
+func newTLSServer(ca *testCA, handler http.Handler) *httptest.Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+// discardLogger silences the expected TLS handshake errors.
+func discardLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
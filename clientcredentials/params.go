@@ -0,0 +1,63 @@
+package clientcredentials
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+// tokenRequestForm creates the token request form, holding option
+// TokenRequestParams plus the scope, audience and resource parameters
+// from tr. Parameters set afterwards, like grant_type and client
+// authentication, replace any extra parameter with the same name.
+func (o *Options) tokenRequestForm(tr TokenRequest) url.Values {
+	form := url.Values{}
+	for k, v := range o.TokenRequestParams {
+		form[k] = append([]string(nil), v...)
+	}
+	if tr.Scope != "" {
+		form.Set("scope", tr.Scope)
+	}
+	if tr.Audience != "" {
+		form.Set("audience", tr.Audience)
+	}
+	if len(tr.Resource) > 0 {
+		form["resource"] = append([]string(nil), tr.Resource...)
+	}
+	return form
+}
+
+// tokenRequestKey derives the cache key from the token request parameters
+// that change the issued token: scope, audience, resource, plus options
+// TokenRequestParams and TokenRequestHeader. The scope only counts when
+// it differs from option Scope, thus the client's own token keeps the
+// default key "" unless other parameters are defined.
+// The key is hashed in order to keep header values, possibly secret,
+// out of the cache.
+func (o *Options) tokenRequestKey(tr TokenRequest) string {
+	scope := tr.Scope
+	tr.Scope = ""
+	params := o.tokenRequestForm(tr)
+	switch {
+	case scope != o.Scope:
+		params.Set("scope", scope)
+	case scope != "":
+		params.Del("scope") // scope from TokenRequestParams is overridden
+	}
+
+	if len(params) == 0 && len(o.TokenRequestHeader) == 0 {
+		return "" // default key
+	}
+
+	key := url.Values{}
+	for k, v := range params {
+		key["param:"+k] = v
+	}
+	for k, v := range o.TokenRequestHeader {
+		key["header:"+http.CanonicalHeaderKey(k)] = v
+	}
+
+	sum := sha256.Sum256([]byte(key.Encode())) // Encode sorts by key
+	return "params:" + hex.EncodeToString(sum[:])
+}
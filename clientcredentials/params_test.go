@@ -0,0 +1,187 @@
+package clientcredentials
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// go test -run TestTokenRequestParams -count 1 ./clientcredentials
+func TestTokenRequestParams(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	var received string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Authorization")
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Resource:     []string{"https://api1", "https://api2"},
+		Audience:     "aud1",
+		TokenRequestParams: url.Values{
+			"organization": {"org1"},
+			"grant_type":   {"password"}, // must not override
+		},
+		TokenRequestHeader: http.Header{"x-tenant": {"tenant1"}},
+	})
+
+	_, errSend := send(client, srv.URL)
+	if errSend != nil {
+		t.Fatalf("send: %v", errSend)
+	}
+
+	const expected = "Bearer |aud1|https://api1,https://api2|org1|tenant1"
+	if received != expected {
+		t.Errorf("expected authorization '%s', got '%s'", expected, received)
+	}
+}
+
+// go test -run TestTokenRequestParamsCacheKey -count 1 ./clientcredentials
+func TestTokenRequestParamsCacheKey(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	var received []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cache := token.NewMemoryCache() // shared between clients
+
+	newClient := func(audience string) *Client {
+		return New(Options{
+			TokenURL:     ts.URL,
+			ClientID:     "clientID",
+			ClientSecret: "clientSecret",
+			Audience:     audience,
+			Cache:        cache,
+		})
+	}
+
+	client1 := newClient("aud1")
+	client2 := newClient("aud2")
+	client3 := newClient("")
+
+	for _, c := range []*Client{client1, client2, client3, client1, client2, client3} {
+		if _, errSend := send(c, srv.URL); errSend != nil {
+			t.Fatalf("send: %v", errSend)
+		}
+	}
+
+	if tokenServerStat.count != 3 {
+		t.Errorf("expected 3 token requests, got %d", tokenServerStat.count)
+	}
+
+	expected := []string{
+		"Bearer |aud1|||", "Bearer |aud2|||", "Bearer ||||",
+		"Bearer |aud1|||", "Bearer |aud2|||", "Bearer ||||",
+	}
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Errorf("expected tokens %v, got %v", expected, received)
+	}
+}
+
+// go test -run TestTokenRequestKey -count 1 ./clientcredentials
+func TestTokenRequestKey(t *testing.T) {
+
+	if key := (&Options{}).newTokenTarget(true).key; key != "" {
+		t.Errorf("expected default key, got '%s'", key)
+	}
+
+	keys := map[string]bool{}
+
+	for _, o := range []Options{
+		{Audience: "aud1"},
+		{Audience: "aud2"},
+		{Resource: []string{"aud1"}},
+		{TokenRequestParams: url.Values{"audience": {"aud3"}}},
+		{TokenRequestHeader: http.Header{"Audience": {"aud3"}}},
+	} {
+		key := o.newTokenTarget(true).key
+		if keys[key] {
+			t.Errorf("duplicate key for options: %+v", o)
+		}
+		keys[key] = true
+	}
+
+	a := Options{TokenRequestHeader: http.Header{"x-tenant": {"t1"}}}
+	b := Options{TokenRequestHeader: http.Header{"X-Tenant": {"t1"}}}
+	if a.newTokenTarget(true).key != b.newTokenTarget(true).key {
+		t.Errorf("header name case must not change the key")
+	}
+}
+
+// go test -run TestTokenRequestParamsLegacyCache -count 1 ./clientcredentials
+func TestTokenRequestParamsLegacyCache(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Audience:     "aud1",
+		Cache:        &legacyCache{},
+	})
+
+	for range 2 {
+		if _, errSend := send(client, srv.URL); errSend != nil {
+			t.Fatalf("send: %v", errSend)
+		}
+	}
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("expected 1 token request, got %d", tokenServerStat.count)
+	}
+}
+
+// newTokenServerParams creates a token server that issues the token
+// "scope|audience|resource|organization|tenant", echoing the token request.
+func newTokenServerParams(serverInfo *serverStat) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		serverInfo.inc()
+
+		r.ParseForm()
+		if formParam(r, "grant_type") != "client_credentials" {
+			httpJSON(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+
+		t := strings.Join([]string{
+			formParam(r, "scope"),
+			formParam(r, "audience"),
+			strings.Join(r.Form["resource"], ","),
+			formParam(r, "organization"),
+			r.Header.Get("X-Tenant"),
+		}, "|")
+
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s","expires_in":300}`, t), http.StatusOK)
+	}))
+}
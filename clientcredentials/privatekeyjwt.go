@@ -0,0 +1,165 @@
+package clientcredentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ParsePrivateKeyPEM parses a PEM-encoded RSA, ECDSA or Ed25519 private key,
+// in PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) form.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key: no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, isSigner := key.(crypto.Signer)
+		if !isSigner {
+			return nil, fmt.Errorf("private key: unsupported key type: %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("private key: unsupported PEM block: %s", block.Type)
+}
+
+// privateKey gets the private key from option PrivateKey or PrivateKeyPEM.
+func (c *Client) privateKey() (crypto.Signer, error) {
+	if c.options.PrivateKey != nil {
+		return c.options.PrivateKey, nil
+	}
+	if c.options.PrivateKeyPEM == nil {
+		return nil, errors.New("private_key_jwt requires option PrivateKey or PrivateKeyPEM")
+	}
+	c.privateKeyOnce.Do(func() {
+		c.parsedKey, c.parsedKeyErr = ParsePrivateKeyPEM(c.options.PrivateKeyPEM)
+	})
+	return c.parsedKey, c.parsedKeyErr
+}
+
+// privateKeyAssertion creates a fresh client assertion signed with the
+// private key, for private_key_jwt.
+func (c *Client) privateKeyAssertion() (string, error) {
+	key, errKey := c.privateKey()
+	if errKey != nil {
+		return "", errKey
+	}
+
+	alg, errAlg := signingAlgorithm(key, c.options.AssertionAlgorithm)
+	if errAlg != nil {
+		return "", errAlg
+	}
+
+	return c.clientAssertion(alg, func(signingInput []byte) ([]byte, error) {
+		return signJWS(key, alg, signingInput)
+	})
+}
+
+// signingAlgorithm finds the JWS algorithm for the key.
+// If alg is defined, it must be compatible with the key.
+func signingAlgorithm(key crypto.Signer, alg string) (string, error) {
+	var supported []string
+
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		supported = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			supported = []string{"ES256"}
+		case elliptic.P384():
+			supported = []string{"ES384"}
+		case elliptic.P521():
+			supported = []string{"ES512"}
+		default:
+			return "", fmt.Errorf("unsupported ECDSA curve: %s", k.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		supported = []string{"EdDSA"}
+	default:
+		return "", fmt.Errorf("unsupported private key type: %T", k)
+	}
+
+	if alg == "" {
+		return supported[0], nil
+	}
+
+	for _, s := range supported {
+		if s == alg {
+			return alg, nil
+		}
+	}
+
+	return "", fmt.Errorf("signing algorithm %s not supported by key type %T", alg, key.Public())
+}
+
+// signJWS signs the JWS signing input with algorithm alg.
+func signJWS(key crypto.Signer, alg string, signingInput []byte) ([]byte, error) {
+	if alg == "EdDSA" {
+		return key.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	var opts crypto.SignerOpts = hash
+	if alg[0] == 'P' {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+
+	sig, errSign := key.Sign(rand.Reader, digest, opts)
+	if errSign != nil {
+		return nil, errSign
+	}
+
+	if alg[0] != 'E' {
+		return sig, nil
+	}
+
+	// JWS requires the raw R||S form, not the ASN.1 form from crypto.Signer
+	pub := key.Public().(*ecdsa.PublicKey)
+	return ecdsaRawSignature(sig, (pub.Curve.Params().BitSize+7)/8)
+}
+
+// ecdsaRawSignature converts an ASN.1 ECDSA signature to the fixed-size
+// R||S form.
+func ecdsaRawSignature(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("ecdsa signature: %w", err)
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
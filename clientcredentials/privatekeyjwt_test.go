@@ -0,0 +1,298 @@
+package clientcredentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -run TestPrivateKeyJWT -count 1 ./clientcredentials
+func TestPrivateKeyJWT(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	testCases := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+		want string
+	}{
+		{"rsa", rsaKey, "", "RS256"},
+		{"rsa pss", rsaKey, "PS384", "PS384"},
+		{"ecdsa", ecKey, "", "ES384"},
+		{"ed25519", edKey, "", "EdDSA"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			clock := newFakeClock()
+
+			tokenServerStat := assertionStat{}
+			ts := newTokenServerAssertion(&tokenServerStat, "clientID", "https://issuer/token", tc.key.Public())
+			defer ts.Close()
+
+			client := New(Options{
+				TokenURL:           ts.URL,
+				ClientID:           "clientID",
+				AuthMethod:         AuthMethodPrivateKeyJWT,
+				PrivateKey:         tc.key,
+				AssertionAlgorithm: tc.alg,
+				AssertionKeyID:     "key-1",
+				AssertionAudience:  "https://issuer/token",
+				AssertionLifetime:  2 * time.Minute,
+				TimeSource:         clock.Now,
+			})
+
+			for range 2 {
+				if _, err := client.fetchToken(t.Context()); err != nil {
+					t.Fatalf("fetch token: %v", err)
+				}
+			}
+
+			assertions := tokenServerStat.assertions()
+			if len(assertions) != 2 {
+				t.Fatalf("unexpected assertion count: %d", len(assertions))
+			}
+
+			header, claims := assertions[0].header, assertions[0].claims
+
+			if header.Alg != tc.want {
+				t.Errorf("alg: expected=%s got=%s", tc.want, header.Alg)
+			}
+			if header.Kid != "key-1" {
+				t.Errorf("kid: %s", header.Kid)
+			}
+			if claims.Iss != "clientID" || claims.Sub != "clientID" {
+				t.Errorf("iss/sub: %s/%s", claims.Iss, claims.Sub)
+			}
+			if claims.Aud != "https://issuer/token" {
+				t.Errorf("aud: %s", claims.Aud)
+			}
+			if claims.Iat != clock.Now().Unix() || claims.Exp-claims.Iat != 120 {
+				t.Errorf("iat/exp: %d/%d", claims.Iat, claims.Exp)
+			}
+			if claims.Jti == "" || claims.Jti == assertions[1].claims.Jti {
+				t.Errorf("jti must be unique per request: %s %s", claims.Jti, assertions[1].claims.Jti)
+			}
+		})
+	}
+}
+
+// go test -run TestPrivateKeyJWTFromPEM -count 1 ./clientcredentials
+func TestPrivateKeyJWTFromPEM(t *testing.T) {
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(ecKey)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	tokenServerStat := assertionStat{}
+	ts := newTokenServerAssertion(&tokenServerStat, "clientID", "", ecKey.Public())
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:      ts.URL,
+		ClientID:      "clientID",
+		AuthMethod:    AuthMethodPrivateKeyJWT,
+		PrivateKeyPEM: pemKey,
+	})
+
+	if _, err := client.fetchToken(t.Context()); err != nil {
+		t.Fatalf("fetch token: %v", err)
+	}
+
+	claims := tokenServerStat.assertions()[0].claims
+	if claims.Aud != ts.URL {
+		t.Errorf("aud should default to token URL: %s", claims.Aud)
+	}
+	if claims.Exp-claims.Iat != 60 {
+		t.Errorf("lifetime should default to 1 minute: %d", claims.Exp-claims.Iat)
+	}
+}
+
+// go test -run TestPrivateKeyJWTErrors -count 1 ./clientcredentials
+func TestPrivateKeyJWTErrors(t *testing.T) {
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	testCases := []struct {
+		name    string
+		options Options
+	}{
+		{"missing key", Options{}},
+		{"bad pem", Options{PrivateKeyPEM: []byte("garbage")}},
+		{"bad algorithm", Options{PrivateKey: edKey, AssertionAlgorithm: "RS256"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokenServerStat := assertionStat{}
+			ts := newTokenServerAssertion(&tokenServerStat, "clientID", "", edKey.Public())
+			defer ts.Close()
+
+			options := tc.options
+			options.TokenURL = ts.URL
+			options.ClientID = "clientID"
+			options.AuthMethod = AuthMethodPrivateKeyJWT
+			options.RetryTokenAttempts = 3
+
+			client := New(options)
+
+			if _, err := client.fetchToken(t.Context()); err == nil {
+				t.Errorf("expected error")
+			}
+			if tokenServerStat.count != 0 {
+				t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	testCases := []struct {
+		name  string
+		block *pem.Block
+	}{
+		{"pkcs1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{"pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}},
+	}
+
+	for _, tc := range testCases {
+		if _, err := ParsePrivateKeyPEM(pem.EncodeToMemory(tc.block)); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+
+	if _, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")})); err == nil {
+		t.Errorf("expected error for bad key")
+	}
+}
+
+type assertion struct {
+	header jwtHeader
+	claims jwtClaims
+}
+
+// assertionStat records the client assertions verified by the token server.
+type assertionStat struct {
+	serverStat
+	verified []assertion
+}
+
+// assertions gets the client assertions verified by the token server.
+func (stat *assertionStat) assertions() []assertion {
+	stat.mutex.Lock()
+	defer stat.mutex.Unlock()
+	return stat.verified
+}
+
+// newTokenServerAssertion creates a token server that verifies the
+// client assertion signature with key.
+func newTokenServerAssertion(serverInfo *assertionStat, clientID, audience string, key any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		serverInfo.inc()
+
+		r.ParseForm()
+
+		if formParam(r, "client_assertion_type") != ClientAssertionTypeJWTBearer ||
+			formParam(r, "client_id") != clientID || formParam(r, "client_secret") != "" {
+			httpJSON(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+
+		a, err := verifyJWT(formParam(r, "client_assertion"), key)
+		if err != nil {
+			httpJSON(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if audience != "" && a.claims.Aud != audience {
+			httpJSON(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		serverInfo.mutex.Lock()
+		serverInfo.verified = append(serverInfo.verified, a)
+		serverInfo.mutex.Unlock()
+
+		httpJSON(w, `{"access_token":"abc"}`, http.StatusOK)
+	}))
+}
+
+// verifyJWT verifies the JWT signature with key.
+func verifyJWT(jwt string, key any) (assertion, error) {
+	var a assertion
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return a, fmt.Errorf("bad jwt: %d parts", len(parts))
+	}
+
+	h, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	c, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err := json.Unmarshal(h, &a.header); err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(c, &a.claims); err != nil {
+		return a, err
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+
+	if strings.HasPrefix(a.header.Alg, "HS") {
+		return a, verifyHMAC(a.header.Alg, key.([]byte), signingInput, sig)
+	}
+
+	if a.header.Alg == "EdDSA" {
+		if !ed25519.Verify(key.(ed25519.PublicKey), signingInput, sig) {
+			return a, errors.New("bad EdDSA signature")
+		}
+		return a, nil
+	}
+
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[a.header.Alg[2:]]
+	hh := hash.New()
+	hh.Write(signingInput)
+	digest := hh.Sum(nil)
+
+	switch a.header.Alg[0] {
+	case 'R':
+		return a, rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), hash, digest, sig)
+	case 'P':
+		return a, rsa.VerifyPSS(key.(*rsa.PublicKey), hash, digest, sig, nil)
+	case 'E':
+		size := len(sig) / 2
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key.(*ecdsa.PublicKey), digest, r, s) {
+			return a, errors.New("bad ECDSA signature")
+		}
+		return a, nil
+	}
+
+	return a, fmt.Errorf("unsupported alg: %s", a.header.Alg)
+}
@@ -0,0 +1,126 @@
+package clientcredentials
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// startRefresher starts the background refresher.
+func (c *Client) startRefresher() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.refreshCancel = cancel
+	c.refreshDone = make(chan struct{})
+	go c.refreshLoop(ctx)
+}
+
+// Close stops the background refresher, if enabled, waiting for it to
+// exit or for ctx to be done. Close is safe to call more than once.
+func (c *Client) Close(ctx context.Context) error {
+	if c.refreshCancel == nil {
+		return nil
+	}
+	c.refreshCancel()
+	select {
+	case <-c.refreshDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refreshLoop renews the token ahead of its soft expiration, retrying
+// with exponential backoff on failure.
+func (c *Client) refreshLoop(ctx context.Context) {
+	defer close(c.refreshDone)
+
+	var backoff time.Duration
+	var fetched bool
+
+	// jitter is drawn once per renewal, so that rechecks do not
+	// postpone the renewal time
+	jitter := c.refreshJitter()
+
+	for {
+		wait, due := c.refreshDelay(ctx, jitter)
+
+		if due && fetched {
+			// the token we have just fetched is already due for renewal,
+			// since its lifetime is shorter than the soft expire window.
+			wait, due = c.options.BackgroundRefreshMinBackoff, false
+		}
+
+		fetched = false
+
+		if due {
+			c.debugf("background refresh: renewing token")
+			_, errFetch := c.fetchToken(ctx)
+			if ctx.Err() != nil {
+				return // closed
+			}
+			if errFetch == nil {
+				backoff = 0
+				fetched = true
+				jitter = c.refreshJitter()
+				continue // schedule next renewal from new token
+			}
+			backoff = nextBackoff(backoff, c.options.BackgroundRefreshMinBackoff,
+				c.options.BackgroundRefreshMaxBackoff)
+			c.errorf("background refresh: retrying in %v: %v", backoff, errFetch)
+			wait = backoff
+		}
+
+		c.debugf("background refresh: next check in %v", wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.options.After(wait):
+		}
+	}
+}
+
+// refreshJitter draws how long before the soft expiration the token is
+// renewed, within option BackgroundRefreshJitter.
+func (c *Client) refreshJitter() time.Duration {
+	if jitter := c.options.BackgroundRefreshJitter; jitter > 0 {
+		return rand.N(jitter)
+	}
+	return 0
+}
+
+// refreshDelay finds how long to wait before renewing the cached token,
+// jitter ahead of its soft expiration.
+// It reports whether the token is due for renewal right now.
+func (c *Client) refreshDelay(ctx context.Context, jitter time.Duration) (time.Duration, bool) {
+	t, errCache := c.cache.GetToken(ctx, c.cacheKey(ctx))
+	if errCache != nil {
+		c.errorf("background refresh: cache get error: %v", errCache)
+		return 0, true
+	}
+
+	if !t.Expirable {
+		// token without expiration: just check it again later,
+		// since it might be expired when refused by the server.
+		return c.options.BackgroundRefreshMaxBackoff, false
+	}
+
+	softExpire := time.Duration(c.options.SoftExpireInSeconds) * time.Second
+
+	renewAt := t.Deadline.Add(-softExpire - jitter)
+
+	wait := renewAt.Sub(c.options.TimeSource())
+	if wait <= 0 {
+		return 0, true
+	}
+
+	return wait, false
+}
+
+// nextBackoff doubles the backoff within [minBackoff, maxBackoff].
+func nextBackoff(backoff, minBackoff, maxBackoff time.Duration) time.Duration {
+	if backoff < minBackoff {
+		return minBackoff
+	}
+	return min(2*backoff, maxBackoff)
+}
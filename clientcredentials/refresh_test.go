@@ -0,0 +1,254 @@
+package clientcredentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -run TestBackgroundRefresh -count 1 ./clientcredentials
+func TestBackgroundRefresh(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, "clientID", "clientSecret", "abc", 60)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:                ts.URL,
+		ClientID:                "clientID",
+		ClientSecret:            "clientSecret",
+		SoftExpireInSeconds:     10,
+		BackgroundRefresh:       true,
+		BackgroundRefreshJitter: -1, // no jitter
+		TimeSource:              clock.Now,
+		After:                   clock.After,
+	})
+	defer client.Close(context.TODO())
+
+	// refresher fetches first token, then waits for renewal time
+
+	clock.waitTimer(t)
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// requests use the prefetched token
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// renewal is due at 60-10 = 50 seconds
+
+	clock.Advance(49 * time.Second) // timer not fired yet
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("renewed too early: token server access count: %d", tokenServerStat.count)
+	}
+
+	clock.Advance(time.Second)
+	clock.waitTimer(t)
+
+	if tokenServerStat.count != 2 {
+		t.Errorf("not renewed: token server access count: %d", tokenServerStat.count)
+	}
+
+	// request within the former soft expire window does not fetch token
+
+	clock.Advance(5 * time.Second)
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestBackgroundRefreshBackoff -count 1 ./clientcredentials
+func TestBackgroundRefreshBackoff(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServerFailing(&tokenServerStat, 3, "abc", 60)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:                    ts.URL,
+		ClientID:                    "clientID",
+		ClientSecret:                "clientSecret",
+		BackgroundRefresh:           true,
+		BackgroundRefreshJitter:     -1, // no jitter
+		BackgroundRefreshMinBackoff: time.Second,
+		BackgroundRefreshMaxBackoff: 3 * time.Second,
+		TimeSource:                  clock.Now,
+		After:                       clock.After,
+	})
+	defer client.Close(context.TODO())
+
+	// backoff: 1s, 2s, 3s (capped), then success
+
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		d := clock.waitTimer(t)
+		if d != backoff {
+			t.Errorf("failure %d: unexpected backoff: expected=%v got=%v", i+1, backoff, d)
+		}
+		if tokenServerStat.count != i+1 {
+			t.Errorf("failure %d: unexpected token server access count: %d", i+1, tokenServerStat.count)
+		}
+		clock.Advance(d)
+	}
+
+	d := clock.waitTimer(t)
+	if tokenServerStat.count != 4 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if d != 50*time.Second {
+		t.Errorf("unexpected renewal schedule after success: %v", d)
+	}
+}
+
+// go test -run TestBackgroundRefreshClose -count 1 ./clientcredentials
+func TestBackgroundRefreshClose(t *testing.T) {
+
+	clock := newFakeClock()
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, "clientID", "clientSecret", "abc", 60)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:          ts.URL,
+		ClientID:          "clientID",
+		ClientSecret:      "clientSecret",
+		BackgroundRefresh: true,
+		TimeSource:        clock.Now,
+		After:             clock.After,
+	})
+
+	clock.waitTimer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if err := client.Close(ctx); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+
+	if tokenServerStat.count != 1 {
+		t.Errorf("refresher still running after close: token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestCloseWithoutBackgroundRefresh -count 1 ./clientcredentials
+func TestCloseWithoutBackgroundRefresh(t *testing.T) {
+	client := New(Options{TokenURL: "http://localhost/token"})
+	if err := client.Close(context.TODO()); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
+
+// fakeClock provides TimeSource and After driven by Advance.
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	created chan time.Duration
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		created: make(chan time.Duration, 100),
+	}
+}
+
+// Now returns the fake time.
+func (fc *fakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+// After creates a timer that fires when the fake time reaches now+d.
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	fc.timers = append(fc.timers, fakeTimer{deadline: fc.now.Add(d), ch: ch})
+	fc.created <- d
+	return ch
+}
+
+// Advance moves the fake time forward, firing due timers.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = fc.now.Add(d)
+	var pending []fakeTimer
+	for _, timer := range fc.timers {
+		if fc.now.Before(timer.deadline) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- fc.now
+	}
+	fc.timers = pending
+}
+
+// waitTimer waits for the refresher to create a timer, returning its duration.
+func (fc *fakeClock) waitTimer(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-fc.created:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for refresher timer")
+	}
+	return 0
+}
+
+// newTokenServerFailing creates a token server that fails the first
+// failures requests.
+func newTokenServerFailing(serverInfo *serverStat, failures int, token string, expireIn int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serverInfo.mutex.Lock()
+		serverInfo.count++
+		n := serverInfo.count
+		serverInfo.mutex.Unlock()
+		if n <= failures {
+			httpJSON(w, `{"error":"temporarily_unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s","expires_in":%d}`, token, expireIn), http.StatusOK)
+	}))
+}
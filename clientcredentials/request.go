@@ -0,0 +1,243 @@
+package clientcredentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TokenResponse holds the token server response, as defined in RFC 6749
+// section 5.1, plus id_token from OpenID Connect.
+type TokenResponse struct {
+	AccessToken  string
+	TokenType    string
+	ExpiresIn    int64 // seconds, 0 if absent
+	RefreshToken string
+	Scope        string
+	IDToken      string
+
+	// Extra holds the non-standard fields, like Azure's ext_expires_in
+	// and expires_on. JSON numbers are kept as json.Number, and fields
+	// from form-urlencoded responses are kept as string.
+	Extra map[string]any
+}
+
+// DefaultTokenResponseBodyLimit is used as default when option
+// TokenResponseBodyLimit is left undefined.
+const DefaultTokenResponseBodyLimit = 1024 * 1024
+
+// sendTokenRequestWith sends the client credentials token request,
+// authenticating the client with method.
+// It also returns the token server response, if any, in order to classify
+// the failure. The response body is already consumed and closed.
+func (c *Client) sendTokenRequestWith(ctx context.Context, method AuthMethod) (*TokenResponse, *http.Response, error) {
+
+	form := c.options.tokenRequestForm(c.target(ctx).TokenRequest)
+	form.Set("grant_type", "client_credentials")
+
+	header := http.Header{}
+
+	switch method {
+	case AuthMethodPost:
+		form.Set("client_id", c.options.ClientID)
+		form.Set("client_secret", c.options.ClientSecret)
+	case AuthMethodBasic:
+		header.Set("Authorization", basicAuth(c.options.ClientID, c.options.ClientSecret))
+	case AuthMethodNone, AuthMethodTLSClientAuth, AuthMethodSelfSignedTLSClientAuth:
+		form.Set("client_id", c.options.ClientID)
+	case AuthMethodPrivateKeyJWT, AuthMethodClientSecretJWT:
+		assertion, errAssertion := c.assertion(method)
+		if errAssertion != nil {
+			return nil, nil, fmt.Errorf("%s: %w", method, errAssertion)
+		}
+		form.Set("client_id", c.options.ClientID)
+		form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+		form.Set("client_assertion", assertion)
+	default:
+		return nil, nil, fmt.Errorf("unsupported auth method: %q", method)
+	}
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL(),
+		strings.NewReader(form.Encode()))
+	if errReq != nil {
+		return nil, nil, errReq
+	}
+
+	for k, v := range c.options.TokenRequestHeader {
+		req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, errDo := c.doTokenRequest(req)
+	if errDo != nil {
+		return nil, nil, errDo
+	}
+
+	defer resp.Body.Close()
+
+	if err := c.isTokenStatusOk(resp.StatusCode); err != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, c.options.TokenResponseBodyLimit))
+		return nil, resp, newTokenError(resp.StatusCode, body, err)
+	}
+
+	limit := c.options.TokenResponseBodyLimit
+
+	body, errRead := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if errRead != nil {
+		return nil, resp, errRead
+	}
+	if int64(len(body)) > limit {
+		return nil, resp, fmt.Errorf("token response body exceeds limit of %d bytes", limit)
+	}
+
+	tr, errParse := parseTokenResponse(resp.Header.Get("Content-Type"), body)
+	if errParse != nil {
+		return nil, resp, errParse
+	}
+
+	return tr, resp, nil
+}
+
+// isTokenStatusOk checks the token server response status with option
+// IsTokenStatusCodeOk, defaulting to any 2xx.
+func (c *Client) isTokenStatusOk(status int) error {
+	if c.options.IsTokenStatusCodeOk != nil {
+		return c.options.IsTokenStatusCodeOk(status)
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("token server status code out of range 200-299: %d", status)
+	}
+	return nil
+}
+
+// parseTokenResponse decodes the token response body according to
+// its content type. Missing content type is decoded as JSON.
+func parseTokenResponse(contentType string, body []byte) (*TokenResponse, error) {
+	mediaType := "application/json"
+	if contentType != "" {
+		mt, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("token response content type: %w", err)
+		}
+		mediaType = mt
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return parseTokenResponseJSON(body)
+	case mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain":
+		return parseTokenResponseForm(body)
+	}
+
+	return nil, fmt.Errorf("unsupported token response content type: %s", contentType)
+}
+
+func parseTokenResponseJSON(body []byte) (*TokenResponse, error) {
+	var fields map[string]any
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("token response json: %w", err)
+	}
+
+	tr := &TokenResponse{Extra: map[string]any{}}
+
+	for k, v := range fields {
+		switch k {
+		case "access_token":
+			tr.AccessToken = jsonString(v)
+		case "token_type":
+			tr.TokenType = jsonString(v)
+		case "refresh_token":
+			tr.RefreshToken = jsonString(v)
+		case "scope":
+			tr.Scope = jsonString(v)
+		case "id_token":
+			tr.IDToken = jsonString(v)
+		case "expires_in":
+			expiresIn, err := parseExpiresIn(jsonString(v))
+			if err != nil {
+				return nil, err
+			}
+			tr.ExpiresIn = expiresIn
+		default:
+			tr.Extra[k] = v
+		}
+	}
+
+	return tr, nil
+}
+
+func parseTokenResponseForm(body []byte) (*TokenResponse, error) {
+	values, errParse := url.ParseQuery(string(body))
+	if errParse != nil {
+		return nil, fmt.Errorf("token response form: %w", errParse)
+	}
+
+	tr := &TokenResponse{Extra: map[string]any{}}
+
+	for k := range values {
+		v := values.Get(k)
+		switch k {
+		case "access_token":
+			tr.AccessToken = v
+		case "token_type":
+			tr.TokenType = v
+		case "refresh_token":
+			tr.RefreshToken = v
+		case "scope":
+			tr.Scope = v
+		case "id_token":
+			tr.IDToken = v
+		case "expires_in":
+			expiresIn, err := parseExpiresIn(v)
+			if err != nil {
+				return nil, err
+			}
+			tr.ExpiresIn = expiresIn
+		default:
+			tr.Extra[k] = v
+		}
+	}
+
+	return tr, nil
+}
+
+// jsonString converts a JSON string or number to string.
+func jsonString(v any) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case json.Number:
+		return s.String()
+	}
+	return ""
+}
+
+// parseExpiresIn accepts expires_in as integer, or as float, or as
+// string holding any of them, since some servers quote it.
+func parseExpiresIn(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("token response: bad expires_in: %q", s)
+	}
+	return int64(f), nil
+}
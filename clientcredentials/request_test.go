@@ -0,0 +1,123 @@
+package clientcredentials
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// go test -run TestTokenResponseHook -count 1 ./clientcredentials
+func TestTokenResponseHook(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			httpJSON(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		httpJSON(w, `{"access_token":"abc","token_type":"Bearer","expires_in":"3599","ext_expires_in":3599,"expires_on":"1760000000","scope":"read write","refresh_token":"rt","id_token":"it"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	serverStat := serverStat{}
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	var tr *TokenResponse
+
+	client := New(Options{
+		TokenURL:        ts.URL,
+		ClientID:        "clientID",
+		ClientSecret:    "clientSecret",
+		OnTokenResponse: func(resp *TokenResponse) { tr = resp },
+	})
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Fatalf("send: %v", errSend)
+	}
+
+	if tr == nil {
+		t.Fatalf("hook not called")
+	}
+	if tr.AccessToken != "abc" || tr.TokenType != "Bearer" || tr.ExpiresIn != 3599 ||
+		tr.Scope != "read write" || tr.RefreshToken != "rt" || tr.IDToken != "it" {
+		t.Errorf("unexpected token response: %+v", tr)
+	}
+	if tr.Extra["ext_expires_in"] != json.Number("3599") {
+		t.Errorf("unexpected ext_expires_in: %#v", tr.Extra["ext_expires_in"])
+	}
+	if tr.Extra["expires_on"] != "1760000000" {
+		t.Errorf("unexpected expires_on: %#v", tr.Extra["expires_on"])
+	}
+	if _, found := tr.Extra["access_token"]; found {
+		t.Errorf("standard field in extra")
+	}
+}
+
+// go test -run TestTokenResponseBodyLimit -count 1 ./clientcredentials
+func TestTokenResponseBodyLimit(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpJSON(w, `{"access_token":"`+strings.Repeat("x", 200)+`"}`, http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:               ts.URL,
+		ClientID:               "clientID",
+		ClientSecret:           "clientSecret",
+		TokenResponseBodyLimit: 100,
+	})
+
+	_, errSend := send(client, "http://localhost")
+	if errSend == nil || !strings.Contains(errSend.Error(), "exceeds limit") {
+		t.Errorf("expected body limit error, got: %v", errSend)
+	}
+}
+
+func TestParseTokenResponse(t *testing.T) {
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		expectError bool
+		accessToken string
+		expiresIn   int64
+	}{
+		{"json", "application/json", `{"access_token":"a","expires_in":30}`, false, "a", 30},
+		{"json charset", "application/json; charset=utf-8", `{"access_token":"a"}`, false, "a", 0},
+		{"json suffix", "application/vnd.example+json", `{"access_token":"a"}`, false, "a", 0},
+		{"json missing content type", "", `{"access_token":"a"}`, false, "a", 0},
+		{"json quoted expires_in", "application/json", `{"access_token":"a","expires_in":"30"}`, false, "a", 30},
+		{"json float expires_in", "application/json", `{"access_token":"a","expires_in":30.0}`, false, "a", 30},
+		{"json bad expires_in", "application/json", `{"access_token":"a","expires_in":"soon"}`, true, "", 0},
+		{"json broken", "application/json", `{"access_token":`, true, "", 0},
+		{"form", "application/x-www-form-urlencoded", `access_token=a&expires_in=30&token_type=bearer`, false, "a", 30},
+		{"form text plain", "text/plain", `access_token=a`, false, "a", 0},
+		{"unsupported", "text/html", `<html></html>`, true, "", 0},
+		{"bad content type", "/", `{}`, true, "", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := parseTokenResponse(tc.contentType, []byte(tc.body))
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tr.AccessToken != tc.accessToken {
+				t.Errorf("access_token: expected=%s got=%s", tc.accessToken, tr.AccessToken)
+			}
+			if tr.ExpiresIn != tc.expiresIn {
+				t.Errorf("expires_in: expected=%d got=%d", tc.expiresIn, tr.ExpiresIn)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package clientcredentials
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// DefaultRetryBadTokenMethods is used as default when option
+// RetryBadTokenMethods is left undefined. It lists the idempotent methods.
+var DefaultRetryBadTokenMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// sendFunc attaches the access token to the request and sends it.
+type sendFunc func(req *http.Request, t token.Token) (*http.Response, error)
+
+// sendWithRetry sends the request and, if option RetryBadToken is enabled,
+// replays it with a fresh token when the server refuses the token.
+// With option DPoP, the body is also buffered to replay the request when
+// the server demands a DPoP nonce.
+func (c *Client) sendWithRetry(req *http.Request, t token.Token, send sendFunc) (*http.Response, error) {
+
+	var replayable bool
+
+	if c.retryEnabled(req) || c.options.DPoP {
+		var errBuf error
+		replayable, errBuf = c.bufferBody(req)
+		if errBuf != nil {
+			return nil, errBuf
+		}
+	}
+
+	resp, errResp := send(req, t)
+
+	for attempt := 1; ; attempt++ {
+		if errResp != nil {
+			return resp, errResp
+		}
+
+		if c.options.DPoP && isResourceNonceError(resp) {
+			// the server wants a nonce, the token is fine
+			return resp, nil
+		}
+
+		if !c.checkBadToken(req.Context(), resp.StatusCode, t.Value) {
+			return resp, nil
+		}
+
+		if attempt > c.options.RetryBadToken {
+			return resp, nil
+		}
+
+		if !replayable {
+			c.debugf("bad token status=%d: request body is not replayable, not retrying",
+				resp.StatusCode)
+			return resp, nil
+		}
+
+		retry, errRewind := rewind(req)
+		if errRewind != nil {
+			c.errorf("bad token status=%d: request body rewind error, not retrying: %v",
+				resp.StatusCode, errRewind)
+			return resp, nil
+		}
+
+		// discard refused response
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		c.debugf("bad token status=%d: retry %d/%d",
+			resp.StatusCode, attempt, c.options.RetryBadToken)
+
+		if c.options.OnRetryBadToken != nil {
+			c.options.OnRetryBadToken(retry, resp.StatusCode, attempt)
+		}
+
+		newToken, errToken := c.renewToken(retry.Context(), t)
+		if errToken != nil {
+			closeBody(retry)
+			return nil, errToken
+		}
+
+		req = retry
+		t = newToken
+		resp, errResp = send(req, t)
+	}
+}
+
+// renewToken retrieves a token to replace the refused one. If the cache
+// already holds a different token, renewed concurrently, that token is used.
+// Otherwise a new token is fetched through singleflight.
+func (c *Client) renewToken(ctx context.Context, refused token.Token) (token.Token, error) {
+	t, errToken := c.getToken(ctx)
+	if errToken != nil {
+		return token.Token{}, errToken
+	}
+	if t.Value != refused.Value {
+		return t, nil
+	}
+	return c.fetchToken(ctx)
+}
+
+// retryEnabled checks whether the request is eligible for retry.
+func (c *Client) retryEnabled(req *http.Request) bool {
+	return c.options.RetryBadToken > 0 &&
+		slices.Contains(c.options.RetryBadTokenMethods, req.Method)
+}
+
+// bufferBody makes sure the request body can be replayed.
+// If the request lacks GetBody, the body is buffered up to the limit
+// defined by option RetryBadTokenBodyLimit.
+// It reports whether the body is replayable.
+func (c *Client) bufferBody(req *http.Request) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true, nil
+	}
+
+	limit := c.options.RetryBadTokenBodyLimit
+
+	buf, errRead := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if errRead != nil {
+		req.Body.Close()
+		return false, errRead
+	}
+
+	if int64(len(buf)) > limit {
+		// body too large: send it as is, without replay
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return false, nil
+	}
+
+	req.Body.Close()
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+
+	return true, nil
+}
+
+// rewind creates a copy of the request with a fresh body.
+func rewind(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody == nil {
+		return retry, nil // no body
+	}
+	body, errBody := req.GetBody()
+	if errBody != nil {
+		return nil, errBody
+	}
+	retry.Body = body
+	return retry, nil
+}
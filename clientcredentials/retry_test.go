@@ -0,0 +1,285 @@
+package clientcredentials
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// go test -run TestRetryBadToken -count 1 ./clientcredentials
+func TestRetryBadToken(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerSequence(&tokenServerStat)
+	defer ts.Close()
+
+	// only the second token is accepted
+	srv := newServerEcho(&serverStat, "token-2")
+	defer srv.Close()
+
+	var retries int
+
+	client := New(Options{
+		TokenURL:      ts.URL,
+		ClientID:      "clientID",
+		ClientSecret:  "clientSecret",
+		RetryBadToken: 1,
+		OnRetryBadToken: func(_ *http.Request, status, attempt int) {
+			retries++
+			if status != 401 {
+				t.Errorf("hook: unexpected status: %d", status)
+			}
+			if attempt != retries {
+				t.Errorf("hook: unexpected attempt: %d", attempt)
+			}
+		},
+	})
+
+	result, errSend := send(client, srv.URL)
+	if errSend != nil {
+		t.Errorf("send: %v", errSend)
+	}
+	if result.status != 200 {
+		t.Errorf("unexpected status: %d", result.status)
+	}
+	if retries != 1 {
+		t.Errorf("unexpected retry count: %d", retries)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 2 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestRetryBadTokenDisabled -count 1 ./clientcredentials
+func TestRetryBadTokenDisabled(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerSequence(&tokenServerStat)
+	defer ts.Close()
+
+	srv := newServerEcho(&serverStat, "token-2")
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+	})
+
+	result, _ := send(client, srv.URL)
+	if result.status != 401 {
+		t.Errorf("unexpected status: %d", result.status)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 1 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestRetryBadTokenExhausted -count 1 ./clientcredentials
+func TestRetryBadTokenExhausted(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerSequence(&tokenServerStat)
+	defer ts.Close()
+
+	srv := newServerEcho(&serverStat, "token-never")
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:      ts.URL,
+		ClientID:      "clientID",
+		ClientSecret:  "clientSecret",
+		RetryBadToken: 2,
+	})
+
+	result, _ := send(client, srv.URL)
+	if result.status != 401 {
+		t.Errorf("unexpected status: %d", result.status)
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 3 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+}
+
+// go test -run TestRetryBadTokenBody -count 1 ./clientcredentials
+func TestRetryBadTokenBody(t *testing.T) {
+
+	const body = "request-body"
+
+	testCases := []struct {
+		name          string
+		method        string
+		methods       []string
+		bodyLimit     int64
+		body          io.Reader
+		expectStatus  int
+		expectTokens  int
+		expectServers int
+	}{
+		{"buffered body", "PUT", nil, 0, onlyReader{strings.NewReader(body)}, 200, 2, 2},
+		{"body with GetBody", "PUT", nil, -1, strings.NewReader(body), 200, 2, 2},
+		{"body over limit", "PUT", nil, 4, onlyReader{strings.NewReader(body)}, 401, 1, 1},
+		{"method not allowed", "POST", nil, 0, strings.NewReader(body), 401, 1, 1},
+		{"method allowed", "POST", []string{"POST"}, 0, strings.NewReader(body), 200, 2, 2},
+	}
+
+	for _, data := range testCases {
+		t.Run(data.name, func(t *testing.T) {
+
+			tokenServerStat := serverStat{}
+			serverStat := serverStat{}
+
+			ts := newTokenServerSequence(&tokenServerStat)
+			defer ts.Close()
+
+			srv := newServerEcho(&serverStat, "token-2")
+			defer srv.Close()
+
+			client := New(Options{
+				TokenURL:               ts.URL,
+				ClientID:               "clientID",
+				ClientSecret:           "clientSecret",
+				RetryBadToken:          1,
+				RetryBadTokenMethods:   data.methods,
+				RetryBadTokenBodyLimit: data.bodyLimit,
+			})
+
+			req, errReq := http.NewRequestWithContext(context.TODO(), data.method, srv.URL, data.body)
+			if errReq != nil {
+				t.Fatalf("request: %v", errReq)
+			}
+
+			resp, errDo := client.Do(req)
+			if errDo != nil {
+				t.Fatalf("do: %v", errDo)
+			}
+			defer resp.Body.Close()
+
+			echo, errBody := io.ReadAll(resp.Body)
+			if errBody != nil {
+				t.Fatalf("body: %v", errBody)
+			}
+
+			if resp.StatusCode != data.expectStatus {
+				t.Errorf("unexpected status: %d", resp.StatusCode)
+			}
+			if resp.StatusCode == 200 && string(echo) != body {
+				t.Errorf("unexpected replayed body: '%s'", string(echo))
+			}
+			if tokenServerStat.count != data.expectTokens {
+				t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+			}
+			if serverStat.count != data.expectServers {
+				t.Errorf("unexpected server access count: %d", serverStat.count)
+			}
+		})
+	}
+}
+
+// go test -run TestTransportRetryBadToken -count 1 ./clientcredentials
+func TestTransportRetryBadToken(t *testing.T) {
+
+	const body = "request-body"
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerSequence(&tokenServerStat)
+	defer ts.Close()
+
+	srv := newServerEcho(&serverStat, "token-2")
+	defer srv.Close()
+
+	httpClient := NewHTTPClient(Options{
+		TokenURL:      ts.URL,
+		ClientID:      "clientID",
+		ClientSecret:  "clientSecret",
+		RetryBadToken: 1,
+	}, nil)
+
+	req, errReq := http.NewRequestWithContext(context.TODO(), "PUT", srv.URL,
+		onlyReader{strings.NewReader(body)})
+	if errReq != nil {
+		t.Fatalf("request: %v", errReq)
+	}
+
+	resp, errDo := httpClient.Do(req)
+	if errDo != nil {
+		t.Fatalf("do: %v", errDo)
+	}
+	defer resp.Body.Close()
+
+	echo, errBody := io.ReadAll(resp.Body)
+	if errBody != nil {
+		t.Fatalf("body: %v", errBody)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	if string(echo) != body {
+		t.Errorf("unexpected replayed body: '%s'", string(echo))
+	}
+	if req.GetBody != nil {
+		t.Errorf("transport modified caller request")
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// onlyReader hides every method but Read, so that http.NewRequest
+// cannot define GetBody.
+type onlyReader struct {
+	r io.Reader
+}
+
+func (o onlyReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+// newTokenServerSequence creates a token server that issues a new token
+// for every request: token-1, token-2, ...
+func newTokenServerSequence(serverInfo *serverStat) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serverInfo.mutex.Lock()
+		serverInfo.count++
+		n := serverInfo.count
+		serverInfo.mutex.Unlock()
+		httpJSON(w, fmt.Sprintf(`{"access_token":"token-%d"}`, n), http.StatusOK)
+	}))
+}
+
+// newServerEcho creates a server that accepts a single token and echoes
+// back the request body.
+func newServerEcho(stat *serverStat, validToken string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stat.inc()
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer "+validToken {
+			httpJSON(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+}
@@ -0,0 +1,112 @@
+package clientcredentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Standard error codes from RFC 6749 section 5.2.
+// A *TokenError matches them with errors.Is.
+var (
+	ErrInvalidRequest       = errors.New("invalid_request")
+	ErrInvalidClient        = errors.New("invalid_client")
+	ErrInvalidGrant         = errors.New("invalid_grant")
+	ErrUnauthorizedClient   = errors.New("unauthorized_client")
+	ErrUnsupportedGrantType = errors.New("unsupported_grant_type")
+	ErrInvalidScope         = errors.New("invalid_scope")
+)
+
+var tokenErrorCodes = map[string]error{
+	ErrInvalidRequest.Error():       ErrInvalidRequest,
+	ErrInvalidClient.Error():        ErrInvalidClient,
+	ErrInvalidGrant.Error():         ErrInvalidGrant,
+	ErrUnauthorizedClient.Error():   ErrUnauthorizedClient,
+	ErrUnsupportedGrantType.Error(): ErrUnsupportedGrantType,
+	ErrInvalidScope.Error():         ErrInvalidScope,
+}
+
+// TokenErrorBodyLimit is the maximum size of the token server error
+// response body kept in TokenError.Body.
+const TokenErrorBodyLimit = 1024
+
+// TokenError is returned when the token server refuses the token request.
+// It carries the error response defined in RFC 6749 section 5.2.
+//
+// Use errors.As to inspect it, or errors.Is to match the standard codes:
+//
+//	if errors.Is(err, clientcredentials.ErrInvalidClient) {
+//	    // bad client credentials
+//	}
+type TokenError struct {
+	// StatusCode is the HTTP status of the token server response.
+	StatusCode int
+
+	// Code is the error code, like "invalid_client".
+	// It is empty if the response body is not a RFC 6749 error response.
+	Code string
+
+	// Description is the optional human-readable error_description.
+	Description string
+
+	// URI is the optional error_uri pointing to a page about the error.
+	URI string
+
+	// Body is the raw response body, truncated to TokenErrorBodyLimit.
+	Body string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *TokenError) Error() string {
+	msg := fmt.Sprintf("token error: status=%d", e.StatusCode)
+	if e.Code != "" {
+		msg += fmt.Sprintf(" error=%s", e.Code)
+	}
+	if e.Description != "" {
+		msg += fmt.Sprintf(" error_description=%q", e.Description)
+	}
+	if e.URI != "" {
+		msg += fmt.Sprintf(" error_uri=%s", e.URI)
+	}
+	if e.Code == "" && e.Body != "" {
+		msg += fmt.Sprintf(" body=%q", e.Body)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the standard error code sentinels, like ErrInvalidClient.
+func (e *TokenError) Is(target error) bool {
+	sentinel, found := tokenErrorCodes[e.Code]
+	return found && target == sentinel
+}
+
+// newTokenError creates TokenError from the token server error response.
+// The whole body is parsed, but only TokenErrorBodyLimit bytes are kept.
+func newTokenError(status int, body []byte, err error) *TokenError {
+	e := &TokenError{
+		StatusCode: status,
+		Body:       string(body[:min(len(body), TokenErrorBodyLimit)]),
+		Err:        err,
+	}
+
+	var resp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	if json.Unmarshal(body, &resp) == nil {
+		e.Code = resp.Error
+		e.Description = resp.ErrorDescription
+		e.URI = resp.ErrorURI
+	}
+
+	return e
+}
@@ -0,0 +1,126 @@
+package clientcredentials
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// go test -run TestTokenError -count 1 ./clientcredentials
+func TestTokenError(t *testing.T) {
+
+	ts := newTokenServerError(http.StatusUnauthorized,
+		`{"error":"invalid_client","error_description":"bad secret","error_uri":"https://example.com/errors"}`)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "WRONG",
+	})
+
+	_, errSend := send(client, "http://localhost")
+
+	var tokenErr *TokenError
+	if !errors.As(errSend, &tokenErr) {
+		t.Fatalf("expected TokenError, got: %v", errSend)
+	}
+	if tokenErr.StatusCode != 401 {
+		t.Errorf("unexpected status: %d", tokenErr.StatusCode)
+	}
+	if tokenErr.Code != "invalid_client" {
+		t.Errorf("unexpected code: %s", tokenErr.Code)
+	}
+	if tokenErr.Description != "bad secret" {
+		t.Errorf("unexpected description: %s", tokenErr.Description)
+	}
+	if tokenErr.URI != "https://example.com/errors" {
+		t.Errorf("unexpected uri: %s", tokenErr.URI)
+	}
+	if !strings.Contains(tokenErr.Body, `"invalid_client"`) {
+		t.Errorf("unexpected body: %s", tokenErr.Body)
+	}
+	if !errors.Is(errSend, ErrInvalidClient) {
+		t.Errorf("expected ErrInvalidClient")
+	}
+	if errors.Is(errSend, ErrInvalidScope) {
+		t.Errorf("unexpected ErrInvalidScope")
+	}
+}
+
+// go test -run TestTokenErrorNonStandard -count 1 ./clientcredentials
+func TestTokenErrorNonStandard(t *testing.T) {
+
+	ts := newTokenServerError(http.StatusInternalServerError, strings.Repeat("x", 2*TokenErrorBodyLimit))
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+	})
+
+	_, errSend := send(client, "http://localhost")
+
+	var tokenErr *TokenError
+	if !errors.As(errSend, &tokenErr) {
+		t.Fatalf("expected TokenError, got: %v", errSend)
+	}
+	if tokenErr.StatusCode != 500 {
+		t.Errorf("unexpected status: %d", tokenErr.StatusCode)
+	}
+	if tokenErr.Code != "" {
+		t.Errorf("unexpected code: %s", tokenErr.Code)
+	}
+	if len(tokenErr.Body) != TokenErrorBodyLimit {
+		t.Errorf("body not truncated: size=%d", len(tokenErr.Body))
+	}
+	for _, sentinel := range []error{ErrInvalidRequest, ErrInvalidClient, ErrInvalidGrant,
+		ErrUnauthorizedClient, ErrUnsupportedGrantType, ErrInvalidScope} {
+		if errors.Is(errSend, sentinel) {
+			t.Errorf("unexpected match: %v", sentinel)
+		}
+	}
+}
+
+// go test -run TestTokenErrorLongBody -count 1 ./clientcredentials
+func TestTokenErrorLongBody(t *testing.T) {
+
+	description := strings.Repeat("x", 2*TokenErrorBodyLimit)
+
+	ts := newTokenServerError(http.StatusBadRequest,
+		`{"error":"invalid_client","error_description":"`+description+`"}`)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "WRONG",
+	})
+
+	_, errSend := send(client, "http://localhost")
+
+	var tokenErr *TokenError
+	if !errors.As(errSend, &tokenErr) {
+		t.Fatalf("expected TokenError, got: %v", errSend)
+	}
+	if !errors.Is(errSend, ErrInvalidClient) {
+		t.Errorf("expected ErrInvalidClient, got code: %q", tokenErr.Code)
+	}
+	if tokenErr.Description != description {
+		t.Errorf("unexpected description size: %d", len(tokenErr.Description))
+	}
+	if len(tokenErr.Body) != TokenErrorBodyLimit {
+		t.Errorf("body not truncated: size=%d", len(tokenErr.Body))
+	}
+}
+
+// newTokenServerError creates a token server that always fails with
+// status and body.
+func newTokenServerError(status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpJSON(w, body, status)
+	}))
+}
@@ -0,0 +1,153 @@
+package clientcredentials
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// TokenRequest selects the token for a request, so that a single Client
+// holds tokens for distinct scopes, audiences and resources. Empty fields
+// default to options Scope, Audience and Resource.
+//
+// Example:
+//
+//	ctx := clientcredentials.WithTokenRequest(req.Context(),
+//	    clientcredentials.TokenRequest{Scope: "orders:read"})
+//	resp, err := client.Do(req.WithContext(ctx))
+type TokenRequest struct {
+	Scope    string
+	Audience string
+	Resource []string
+}
+
+type tokenRequestContextKey struct{}
+
+// WithTokenRequest returns a copy of ctx that selects the token described
+// by tr for requests sent by Client.Do or Transport.
+//
+// Tokens are stored in the cache under keys derived from tr, thus the
+// cache must implement token.TokenCacheV2, as the default memory cache
+// does. Background refresh only renews the token for the options, and
+// the circuit breaker and negative cache are shared by all tokens, since
+// they track the token server.
+func WithTokenRequest(ctx context.Context, tr TokenRequest) context.Context {
+	return context.WithValue(ctx, tokenRequestContextKey{}, tr)
+}
+
+// tokenTarget is the effective token request, with its cache key.
+type tokenTarget struct {
+	TokenRequest
+	key string // cache and singleflight key
+}
+
+// newTokenTarget creates the target for the token defined by options.
+func (o *Options) newTokenTarget(keyed bool) tokenTarget {
+	tt := tokenTarget{
+		TokenRequest: TokenRequest{
+			Scope:    o.Scope,
+			Audience: o.Audience,
+			Resource: o.Resource,
+		},
+	}
+	if keyed {
+		tt.key = o.tokenRequestKey(tt.TokenRequest)
+	}
+	return tt
+}
+
+// target finds the token selected by the context, merging its
+// TokenRequest with the options. Without TokenRequest, or when it matches
+// the options, the client's own token is selected.
+func (c *Client) target(ctx context.Context) *tokenTarget {
+	tr, found := ctx.Value(tokenRequestContextKey{}).(TokenRequest)
+	if !found {
+		return &c.defaultTarget
+	}
+
+	def := &c.defaultTarget
+	if tr.Scope == "" {
+		tr.Scope = def.Scope
+	}
+	if tr.Audience == "" {
+		tr.Audience = def.Audience
+	}
+	if len(tr.Resource) == 0 {
+		tr.Resource = def.Resource
+	}
+
+	if tr.Scope == def.Scope && tr.Audience == def.Audience && slices.Equal(tr.Resource, def.Resource) {
+		return def
+	}
+
+	return &tokenTarget{TokenRequest: tr, key: c.options.tokenRequestKey(tr)}
+}
+
+// cacheKey gets the key for storing the token in the cache.
+func (c *Client) cacheKey(ctx context.Context) string {
+	return c.target(ctx).key
+}
+
+// selectToken checks the token selected by the context. Tokens other
+// than the client's own are tracked for LRU eviction.
+func (c *Client) selectToken(ctx context.Context) error {
+	tt := c.target(ctx)
+	if tt == &c.defaultTarget {
+		return nil
+	}
+	if !c.keyed {
+		return fmt.Errorf("token request %+v: %w", tt.TokenRequest, token.ErrKeyUnsupported)
+	}
+	for _, evicted := range c.keys.touch(tt.key) {
+		c.debugf("evicting least recently used token: key=%s", evicted)
+		c.breaker.forgetNegative(evicted)
+		if err := c.cache.DeleteToken(ctx, evicted); err != nil {
+			c.errorf("cache delete error: %v", err)
+		}
+	}
+	return nil
+}
+
+// keyLRU tracks the most recently used keys, up to a maximum.
+type keyLRU struct {
+	mutex sync.Mutex
+	max   int // 0 means unbounded
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newKeyLRU(maxKeys int) *keyLRU {
+	return &keyLRU{
+		max:   maxKeys,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// touch marks the key as most recently used. It returns the keys evicted
+// for exceeding the maximum.
+func (l *keyLRU) touch(key string) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elem, found := l.items[key]; found {
+		l.order.MoveToFront(elem)
+		return nil
+	}
+
+	l.items[key] = l.order.PushFront(key)
+
+	var evicted []string
+	for l.max > 0 && l.order.Len() > l.max {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		k := oldest.Value.(string)
+		delete(l.items, k)
+		evicted = append(evicted, k)
+	}
+	return evicted
+}
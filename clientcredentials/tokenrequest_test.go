@@ -0,0 +1,223 @@
+package clientcredentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// go test -run TestTokenRequest -count 1 ./clientcredentials
+func TestTokenRequest(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	var received string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Authorization")
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Scope:        "default",
+	})
+
+	testCases := []struct {
+		name          string
+		tr            *TokenRequest
+		expectedToken string
+		expectedCount int
+	}{
+		{"default", nil, "default||||", 1},
+		{"scope", &TokenRequest{Scope: "orders"}, "orders||||", 2},
+		{"audience", &TokenRequest{Audience: "aud1"}, "default|aud1|||", 3},
+		{"resource", &TokenRequest{Resource: []string{"https://api1"}}, "default||https://api1||", 4},
+		{"scope cached", &TokenRequest{Scope: "orders"}, "orders||||", 4},
+		{"same as default", &TokenRequest{Scope: "default"}, "default||||", 4},
+		{"empty", &TokenRequest{}, "default||||", 4},
+		{"audience cached", &TokenRequest{Audience: "aud1"}, "default|aud1|||", 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.TODO()
+			if tc.tr != nil {
+				ctx = WithTokenRequest(ctx, *tc.tr)
+			}
+
+			req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			if errReq != nil {
+				t.Fatalf("request: %v", errReq)
+			}
+
+			resp, errDo := client.Do(req)
+			if errDo != nil {
+				t.Fatalf("do: %v", errDo)
+			}
+			resp.Body.Close()
+
+			if received != "Bearer "+tc.expectedToken {
+				t.Errorf("expected token '%s', got '%s'", tc.expectedToken, received)
+			}
+			if tokenServerStat.count != tc.expectedCount {
+				t.Errorf("expected %d token requests, got %d", tc.expectedCount, tokenServerStat.count)
+			}
+		})
+	}
+}
+
+// go test -run TestTokenRequestTransport -count 1 ./clientcredentials
+func TestTokenRequestTransport(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	var received string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Authorization")
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	httpClient := NewHTTPClient(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+	}, nil)
+
+	ctx := WithTokenRequest(context.TODO(), TokenRequest{Scope: "orders", Audience: "aud1"})
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if errReq != nil {
+		t.Fatalf("request: %v", errReq)
+	}
+
+	resp, errDo := httpClient.Do(req)
+	if errDo != nil {
+		t.Fatalf("do: %v", errDo)
+	}
+	resp.Body.Close()
+
+	if received != "Bearer orders|aud1|||" {
+		t.Errorf("unexpected token: '%s'", received)
+	}
+}
+
+// go test -run TestTokenRequestEviction -count 1 ./clientcredentials
+func TestTokenRequestEviction(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		httpJSON(w, `{"message":"ok"}`, http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:         ts.URL,
+		ClientID:         "clientID",
+		ClientSecret:     "clientSecret",
+		MaxTokenRequests: 2,
+	})
+
+	sendScope := func(scope string) {
+		t.Helper()
+		ctx := context.TODO()
+		if scope != "" {
+			ctx = WithTokenRequest(ctx, TokenRequest{Scope: scope})
+		}
+		req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if errReq != nil {
+			t.Fatalf("request: %v", errReq)
+		}
+		resp, errDo := client.Do(req)
+		if errDo != nil {
+			t.Fatalf("do: %v", errDo)
+		}
+		resp.Body.Close()
+	}
+
+	sendScope("")   // default token is never evicted
+	sendScope("s1") // keys: s1
+	sendScope("s2") // keys: s2 s1
+	sendScope("s1") // keys: s1 s2
+	sendScope("s3") // keys: s3 s1, evicts s2
+
+	if tokenServerStat.count != 4 {
+		t.Errorf("expected 4 token requests, got %d", tokenServerStat.count)
+	}
+
+	sendScope("s1") // cached
+	sendScope("")   // cached
+	sendScope("s2") // evicted
+
+	if tokenServerStat.count != 5 {
+		t.Errorf("expected 5 token requests, got %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestTokenRequestLegacyCache -count 1 ./clientcredentials
+func TestTokenRequestLegacyCache(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	ts := newTokenServerParams(&tokenServerStat)
+	defer ts.Close()
+
+	client := New(Options{
+		TokenURL:     ts.URL,
+		ClientID:     "clientID",
+		ClientSecret: "clientSecret",
+		Cache:        &legacyCache{},
+	})
+
+	ctx := WithTokenRequest(context.TODO(), TokenRequest{Scope: "orders"})
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+	if errReq != nil {
+		t.Fatalf("request: %v", errReq)
+	}
+
+	_, errDo := client.Do(req)
+	if !errors.Is(errDo, token.ErrKeyUnsupported) {
+		t.Errorf("expected ErrKeyUnsupported, got: %v", errDo)
+	}
+	if tokenServerStat.count != 0 {
+		t.Errorf("unexpected token requests: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestKeyLRU -count 1 ./clientcredentials
+func TestKeyLRU(t *testing.T) {
+	l := newKeyLRU(2)
+
+	var evicted []string
+	for _, k := range []string{"a", "b", "a", "c", "d", "a"} {
+		evicted = append(evicted, l.touch(k)...)
+	}
+
+	if fmt.Sprint(evicted) != "[b a c]" {
+		t.Errorf("unexpected evicted keys: %v", evicted)
+	}
+
+	unbounded := newKeyLRU(0)
+	for i := range 1000 {
+		if e := unbounded.touch(fmt.Sprint(i)); e != nil {
+			t.Fatalf("unbounded lru evicted: %v", e)
+		}
+	}
+}
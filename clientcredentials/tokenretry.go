@@ -0,0 +1,151 @@
+package clientcredentials
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// DefaultIsTokenStatusRetryable is used as default function when option
+// IsTokenStatusRetryable is left undefined. It accepts the statuses
+// that usually indicate a transient failure: 408, 429, 500, 502, 503
+// and 504.
+func DefaultIsTokenStatusRetryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// requestToken retrieves new token from token server, retrying failures
+// classified as retryable with exponential backoff, as defined by option
+// RetryTokenAttempts.
+func (c *Client) requestToken(ctx context.Context) (token.Token, error) {
+
+	begin := c.options.TimeSource()
+
+	var backoff time.Duration
+
+	for attempt := 1; ; attempt++ {
+		t, resp, err := c.requestTokenOnce(ctx)
+		if err == nil {
+			return t, nil
+		}
+
+		if attempt >= c.options.RetryTokenAttempts || !c.isTokenRetryable(ctx, resp, err) {
+			return t, err
+		}
+
+		backoff = nextBackoff(backoff, c.options.RetryTokenMinBackoff,
+			c.options.RetryTokenMaxBackoff)
+
+		wait := backoff
+		if jitter := c.options.RetryTokenJitter; jitter > 0 {
+			wait += rand.N(jitter)
+		}
+
+		now := c.options.TimeSource()
+
+		if retryAfter, found := parseRetryAfter(resp, now); found {
+			if retryAfter > c.options.RetryTokenMaxBackoff {
+				c.debugf("requestToken: attempt %d: Retry-After %v exceeds max backoff %v: %v",
+					attempt, retryAfter, c.options.RetryTokenMaxBackoff, err)
+				return t, err
+			}
+			wait = retryAfter
+		}
+
+		if budget := c.options.RetryTokenBudget; budget > 0 && now.Add(wait).Sub(begin) > budget {
+			c.debugf("requestToken: attempt %d: retry in %v would exceed budget %v: %v",
+				attempt, wait, budget, err)
+			return t, err
+		}
+
+		c.debugf("requestToken: attempt %d: retrying in %v: %v", attempt, wait, err)
+
+		if c.options.OnRetryToken != nil {
+			c.options.OnRetryToken(attempt, wait, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return t, ctx.Err()
+		case <-c.options.After(wait):
+		}
+	}
+}
+
+// isTokenRetryable checks whether a failed token request is worth retrying.
+// Transient network errors, without response, are retryable unless the
+// context is done. Permanent failures, like bad URL, unsupported scheme,
+// certificate verification failure or too many redirects, are not.
+func (c *Client) isTokenRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if resp == nil {
+		return isTransientNetworkError(err)
+	}
+	return c.options.IsTokenStatusRetryable(resp.StatusCode)
+}
+
+// isTransientNetworkError checks whether err is a network failure likely
+// to go away: timeout, connection refused or reset, or connection closed
+// in the middle of the response.
+func isTransientNetworkError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter extracts the Retry-After header from 429 and 503
+// responses, either as delay in seconds or as HTTP date.
+func parseRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(h); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(h); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,423 @@
+package clientcredentials
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// go test -run TestRetryToken -count 1 ./clientcredentials
+func TestRetryToken(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerFailing(&tokenServerStat, 2, "abc", 0)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	clock := newInstantClock()
+
+	var attempts []int
+
+	client := New(Options{
+		TokenURL:           ts.URL,
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 3,
+		RetryTokenJitter:   -1, // no jitter
+		After:              clock.After,
+		OnRetryToken: func(attempt int, _ time.Duration, _ error) {
+			attempts = append(attempts, attempt)
+		},
+	})
+
+	result, errSend := send(client, srv.URL)
+	if errSend != nil {
+		t.Fatalf("send: %v", errSend)
+	}
+	if result.status != 200 {
+		t.Errorf("unexpected status: %d", result.status)
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if !slices.Equal(attempts, []int{1, 2}) {
+		t.Errorf("unexpected retry hook attempts: %v", attempts)
+	}
+
+	expectedWaits := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if waits := clock.waits(); !slices.Equal(waits, expectedWaits) {
+		t.Errorf("unexpected backoff: expected=%v got=%v", expectedWaits, waits)
+	}
+}
+
+// go test -run TestRetryTokenExhausted -count 1 ./clientcredentials
+func TestRetryTokenExhausted(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerFailing(&tokenServerStat, 5, "abc", 0)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	clock := newInstantClock()
+
+	client := New(Options{
+		TokenURL:             ts.URL,
+		ClientID:             "clientID",
+		ClientSecret:         "clientSecret",
+		RetryTokenAttempts:   3,
+		RetryTokenJitter:     -1, // no jitter
+		RetryTokenMaxBackoff: 150 * time.Millisecond,
+		After:                clock.After,
+	})
+
+	if _, errSend := send(client, srv.URL); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if tokenServerStat.count != 3 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if serverStat.count != 0 {
+		t.Errorf("unexpected server access count: %d", serverStat.count)
+	}
+
+	expectedWaits := []time.Duration{100 * time.Millisecond, 150 * time.Millisecond}
+	if waits := clock.waits(); !slices.Equal(waits, expectedWaits) {
+		t.Errorf("unexpected backoff: expected=%v got=%v", expectedWaits, waits)
+	}
+}
+
+// go test -run TestRetryTokenNotRetryable -count 1 ./clientcredentials
+func TestRetryTokenNotRetryable(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, "clientID", "clientSecret", "abc", 0)
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	client := New(Options{
+		TokenURL:           ts.URL,
+		ClientID:           "clientID",
+		ClientSecret:       "WRONG",
+		RetryTokenAttempts: 3,
+		After:              newInstantClock().After,
+	})
+
+	if _, errSend := send(client, srv.URL); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("401 should not be retried: token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestRetryTokenNetworkError -count 1 ./clientcredentials
+func TestRetryTokenNetworkError(t *testing.T) {
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	tokenURL := ts.URL
+	ts.Close() // connection refused
+
+	var retries int
+
+	client := New(Options{
+		TokenURL:           tokenURL,
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 2,
+		After:              newInstantClock().After,
+		OnRetryToken:       func(int, time.Duration, error) { retries++ },
+	})
+
+	if _, errSend := send(client, "http://localhost"); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if retries != 1 {
+		t.Errorf("unexpected retries: %d", retries)
+	}
+}
+
+// go test -run TestRetryTokenBadURL -count 1 ./clientcredentials
+func TestRetryTokenBadURL(t *testing.T) {
+
+	var retries int
+
+	client := New(Options{
+		TokenURL:           "http://bad host/token",
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 3,
+		After:              newInstantClock().After,
+		OnRetryToken:       func(int, time.Duration, error) { retries++ },
+	})
+
+	if _, errSend := send(client, "http://localhost"); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if retries != 0 {
+		t.Errorf("bad URL should not be retried: retries: %d", retries)
+	}
+}
+
+// go test -run TestRetryTokenCertificateError -count 1 ./clientcredentials
+func TestRetryTokenCertificateError(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+
+	// the default client does not trust the test server certificate
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tokenServerStat.inc()
+		httpJSON(w, `{"access_token":"abc"}`, http.StatusOK)
+	}))
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	defer ts.Close()
+
+	var retries int
+
+	client := New(Options{
+		TokenURL:           ts.URL,
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 3,
+		After:              newInstantClock().After,
+		OnRetryToken:       func(int, time.Duration, error) { retries++ },
+	})
+
+	if _, errSend := send(client, "http://localhost"); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if retries != 0 {
+		t.Errorf("certificate error should not be retried: retries: %d", retries)
+	}
+}
+
+// go test -run TestIsTransientNetworkError -count 1 ./clientcredentials
+func TestIsTransientNetworkError(t *testing.T) {
+
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://token", Err: err}
+	}
+
+	testCases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"unexpected eof", urlErr(io.ErrUnexpectedEOF), true},
+		{"timeout", urlErr(context.DeadlineExceeded), true},
+		{"unsupported scheme", urlErr(errors.New("unsupported protocol scheme \"ftp\"")), false},
+		{"too many redirects", urlErr(errors.New("stopped after 10 redirects")), false},
+		{"unknown authority", urlErr(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), false},
+		{"hostname mismatch", urlErr(x509.HostnameError{Host: "token"}), false},
+		{"parse", &url.Error{Op: "parse", URL: "http://bad host", Err: errors.New("invalid character")}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if transient := isTransientNetworkError(tc.err); transient != tc.transient {
+				t.Errorf("expected transient=%t, got %t", tc.transient, transient)
+			}
+		})
+	}
+}
+
+// go test -run TestRetryTokenRetryAfter -count 1 ./clientcredentials
+func TestRetryTokenRetryAfter(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerRetryAfter(&tokenServerStat, 1, http.StatusTooManyRequests, "3", "abc")
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	clock := newInstantClock()
+
+	client := New(Options{
+		TokenURL:           ts.URL,
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 3,
+		After:              clock.After,
+	})
+
+	if _, errSend := send(client, srv.URL); errSend != nil {
+		t.Fatalf("send: %v", errSend)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	expectedWaits := []time.Duration{3 * time.Second}
+	if waits := clock.waits(); !slices.Equal(waits, expectedWaits) {
+		t.Errorf("Retry-After not honored: expected=%v got=%v", expectedWaits, waits)
+	}
+}
+
+// go test -run TestRetryTokenRetryAfterTooLong -count 1 ./clientcredentials
+func TestRetryTokenRetryAfterTooLong(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServerRetryAfter(&tokenServerStat, 1, http.StatusServiceUnavailable, "3600", "abc")
+	defer ts.Close()
+
+	clock := newInstantClock()
+
+	client := New(Options{
+		TokenURL:             ts.URL,
+		ClientID:             "clientID",
+		ClientSecret:         "clientSecret",
+		RetryTokenAttempts:   3,
+		RetryTokenMaxBackoff: 5 * time.Second,
+		After:                clock.After,
+	})
+
+	if _, errSend := send(client, "http://localhost"); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+	if waits := clock.waits(); len(waits) != 0 {
+		t.Errorf("should not wait for Retry-After beyond max backoff: %v", waits)
+	}
+}
+
+// go test -run TestRetryTokenBudget -count 1 ./clientcredentials
+func TestRetryTokenBudget(t *testing.T) {
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServerRetryAfter(&tokenServerStat, 1, http.StatusServiceUnavailable, "10", "abc")
+	defer ts.Close()
+
+	srv := newServer(&serverStat, func(t string) bool { return t == "abc" })
+	defer srv.Close()
+
+	clock := newInstantClock()
+
+	client := New(Options{
+		TokenURL:           ts.URL,
+		ClientID:           "clientID",
+		ClientSecret:       "clientSecret",
+		RetryTokenAttempts: 3,
+		RetryTokenBudget:   5 * time.Second,
+		After:              clock.After,
+	})
+
+	if _, errSend := send(client, srv.URL); errSend == nil {
+		t.Errorf("expected error")
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("retry beyond budget: token server access count: %d", tokenServerStat.count)
+	}
+	if waits := clock.waits(); len(waits) != 0 {
+		t.Errorf("unexpected waits: %v", waits)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		status   int
+		header   string
+		expected time.Duration
+		found    bool
+	}{
+		{429, "", 0, false},
+		{429, "7", 7 * time.Second, true},
+		{503, "0", 0, true},
+		{503, "-1", 0, false},
+		{503, "soon", 0, false},
+		{503, now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{503, now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{500, "7", 0, false},
+	}
+
+	for _, tc := range testCases {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		if tc.header != "" {
+			resp.Header.Set("Retry-After", tc.header)
+		}
+		d, found := parseRetryAfter(resp, now)
+		if d != tc.expected || found != tc.found {
+			t.Errorf("status=%d header=%q: expected=%v,%t got=%v,%t",
+				tc.status, tc.header, tc.expected, tc.found, d, found)
+		}
+	}
+}
+
+// instantClock provides After that fires immediately, recording the
+// requested durations.
+type instantClock struct {
+	mutex     sync.Mutex
+	durations []time.Duration
+}
+
+func newInstantClock() *instantClock {
+	return &instantClock{}
+}
+
+func (ic *instantClock) After(d time.Duration) <-chan time.Time {
+	ic.mutex.Lock()
+	ic.durations = append(ic.durations, d)
+	ic.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func (ic *instantClock) waits() []time.Duration {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	return slices.Clone(ic.durations)
+}
+
+// newTokenServerRetryAfter creates a token server that fails the first
+// failures requests with status and Retry-After header.
+func newTokenServerRetryAfter(serverInfo *serverStat, failures, status int, retryAfter, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serverInfo.mutex.Lock()
+		serverInfo.count++
+		n := serverInfo.count
+		serverInfo.mutex.Unlock()
+		if n <= failures {
+			w.Header().Set("Retry-After", retryAfter)
+			httpJSON(w, `{"error":"slow_down"}`, status)
+			return
+		}
+		httpJSON(w, fmt.Sprintf(`{"access_token":"%s"}`, token), http.StatusOK)
+	}))
+}
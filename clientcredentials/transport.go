@@ -0,0 +1,82 @@
+package clientcredentials
+
+import (
+	"net/http"
+
+	"github.com/udhos/oauth2/token"
+)
+
+// Transport is an http.RoundTripper that authenticates every request
+// with a token obtained from Client.
+//
+// Transport follows the http.RoundTripper contract: the caller's request
+// is never modified, since the token is set on a clone of the request,
+// and the request body is always closed, even on errors.
+//
+// Example:
+//
+//	client := clientcredentials.New(options)
+//	httpClient := &http.Client{
+//	    Transport: &clientcredentials.Transport{Client: client},
+//	}
+type Transport struct {
+	// Client provides the tokens. Required.
+	Client *Client
+
+	// Base is the underlying transport used to send the requests.
+	// If nil, http.DefaultTransport is used, except in mTLS mode, where
+	// a transport presenting the client certificate is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	tok, errToken := t.Client.getToken(req.Context())
+	if errToken != nil {
+		closeBody(req)
+		return nil, errToken
+	}
+
+	// work on a clone, so that the caller's request is never modified
+	req2 := req.Clone(req.Context())
+
+	send := func(r *http.Request, tok token.Token) (*http.Response, error) {
+		// base transport is responsible for closing the body
+		return t.Client.sendToken(r, tok, t.base().RoundTrip)
+	}
+
+	return t.Client.sendWithRetry(req2, tok, send)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return t.Client.resourceTransport
+}
+
+// closeBody closes the request body, as required by the http.RoundTripper
+// contract, for requests that are not handed to the base transport.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// NewHTTPClient creates an *http.Client that authenticates every request
+// with client-credentials tokens. The tokens are retrieved as in New(options).
+// base is the underlying transport used to send the requests; if nil,
+// http.DefaultTransport is used, except in mTLS mode, where a transport
+// presenting the client certificate is used, as in Transport.Base.
+//
+// Notice options.HTTPClient is still used to send the token requests,
+// not base.
+func NewHTTPClient(options Options, base http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: &Transport{
+			Client: New(options),
+			Base:   base,
+		},
+	}
+}
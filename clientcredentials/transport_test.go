@@ -0,0 +1,182 @@
+package clientcredentials
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// go test -run TestTransport -count 1 ./clientcredentials
+func TestTransport(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	expireIn := 60
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+	disableSingleflight := false
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, clientID, clientSecret, token, expireIn)
+	defer ts.Close()
+
+	validToken := func(t string) bool { return t == token }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	httpClient := &http.Client{Transport: &Transport{Client: client}}
+
+	for i := 1; i <= 2; i++ {
+		req, errReq := http.NewRequestWithContext(context.TODO(), "GET", srv.URL, nil)
+		if errReq != nil {
+			t.Fatalf("request: %v", errReq)
+		}
+
+		resp, errDo := httpClient.Do(req)
+		if errDo != nil {
+			t.Fatalf("do: %v", errDo)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			t.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+		if h := req.Header.Get("Authorization"); h != "" {
+			t.Errorf("transport modified caller request: Authorization=%s", h)
+		}
+		if tokenServerStat.count != 1 {
+			t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+		}
+		if serverStat.count != i {
+			t.Errorf("unexpected server access count: %d", serverStat.count)
+		}
+	}
+}
+
+// go test -run TestTransportBadToken -count 1 ./clientcredentials
+func TestTransportBadToken(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	token := "abc"
+	expireIn := 60
+	softExpire := -1 // disable soft expire
+
+	tokenServerStat := serverStat{}
+	serverStat := serverStat{}
+
+	ts := newTokenServer(&tokenServerStat, clientID, clientSecret, token, expireIn)
+	defer ts.Close()
+
+	accept := "abc"
+	validToken := func(t string) bool { return t == accept }
+
+	srv := newServer(&serverStat, validToken)
+	defer srv.Close()
+
+	options := Options{
+		TokenURL:            ts.URL,
+		ClientID:            clientID,
+		ClientSecret:        clientSecret,
+		SoftExpireInSeconds: softExpire,
+	}
+
+	httpClient := NewHTTPClient(options, nil)
+
+	get := func() int {
+		req, errReq := http.NewRequestWithContext(context.TODO(), "GET", srv.URL, nil)
+		if errReq != nil {
+			t.Fatalf("request: %v", errReq)
+		}
+		resp, errDo := httpClient.Do(req)
+		if errDo != nil {
+			t.Fatalf("do: %v", errDo)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// send 1: get first token
+
+	if status := get(); status != 200 {
+		t.Errorf("unexpected status: %d", status)
+	}
+
+	// send 2: server refuses token, token must be expired
+
+	accept = "other"
+
+	if status := get(); status != 401 {
+		t.Errorf("unexpected status: %d", status)
+	}
+	if tokenServerStat.count != 1 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+
+	// send 3: token must be renewed
+
+	accept = "abc"
+
+	if status := get(); status != 200 {
+		t.Errorf("unexpected status: %d", status)
+	}
+	if tokenServerStat.count != 2 {
+		t.Errorf("unexpected token server access count: %d", tokenServerStat.count)
+	}
+}
+
+// go test -run TestTransportClosesBodyOnError -count 1 ./clientcredentials
+func TestTransportClosesBodyOnError(t *testing.T) {
+
+	clientID := "clientID"
+	clientSecret := "clientSecret"
+	softExpire := 0
+	timeSource := (func() time.Time)(nil)
+	disableSingleflight := false
+
+	tokenServerStat := serverStat{}
+
+	ts := newTokenServerBroken(&tokenServerStat)
+	defer ts.Close()
+
+	client := newClient(t, ts.URL, clientID, clientSecret, softExpire, timeSource, disableSingleflight)
+
+	transport := &Transport{Client: client}
+
+	body := &trackBody{Reader: strings.NewReader("body")}
+
+	req, errReq := http.NewRequestWithContext(context.TODO(), "POST", "http://localhost/", body)
+	if errReq != nil {
+		t.Fatalf("request: %v", errReq)
+	}
+
+	_, errTrip := transport.RoundTrip(req)
+	if errTrip == nil {
+		t.Errorf("unexpected success with broken token server")
+	}
+	if !body.closed.Load() {
+		t.Errorf("request body not closed on error")
+	}
+}
+
+type trackBody struct {
+	io.Reader
+	closed atomic.Bool
+}
+
+func (b *trackBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
@@ -4,11 +4,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +23,9 @@ type application struct {
 	clientID            string
 	clientSecret        string
 	scope               string
+	authMethod          string
+	audience            string
+	resource            string
 	targetURL           string
 	targetMethod        string
 	targetBody          string
@@ -41,13 +46,16 @@ func main() {
 	flag.StringVar(&app.clientID, "clientID", "admin", "client ID")
 	flag.StringVar(&app.clientSecret, "clientSecret", "admin", "client secret")
 	flag.StringVar(&app.scope, "scope", "", "space-delimited list of scopes")
+	flag.StringVar(&app.authMethod, "authMethod", "", "client authentication method: client_secret_post (default), client_secret_basic, none, auto")
+	flag.StringVar(&app.audience, "audience", "", "audience parameter for the token request")
+	flag.StringVar(&app.resource, "resource", "", "comma-separated list of resource indicators for the token request")
 	flag.StringVar(&app.targetURL, "targetURL", "https://httpbin.org/get", "target URL")
 	flag.StringVar(&app.targetMethod, "targetMethod", "GET", "target method")
 	flag.StringVar(&app.targetBody, "targetBody", "targetBody", "target body")
 	flag.IntVar(&app.count, "count", 2, "how many requests to send")
 	flag.IntVar(&app.softExpireSeconds, "softExpireSeconds", 10, "token soft expire in seconds")
 	flag.DurationVar(&app.interval, "interval", 2*time.Second, "interval between sends")
-	flag.StringVar(&app.cache, "cache", "", "empty means default memory cache\n'file:<path>' means filecache (example: file:/tmp/cache)\n'error' means errorcache\nredis format: 'redis:<host>:<port>:<password>:<key>' (example: redis:localhost:6379::oauth2-client-example\nredis key: leave key empty for auto generation: 'redis:<host>:<port>:<password>:' (example: redis:localhost:6379::)")
+	flag.StringVar(&app.cache, "cache", "", "empty means default memory cache\n'file:<path>' means filecache (example: file:/tmp/cache)\n'error' means errorcache\nredis format: 'redis:<host>:<port>:<password>:<key>' (example: redis:localhost:6379::oauth2-client-example\nredis key: leave key empty for auto generation: 'redis:<host>:<port>:<password>:' (example: redis:localhost:6379::)\nURL forms: 'file:///<path>?mode=0600', 'redis://[user:pass@]host[:port][/db][?key=<key>&tls=true&dial_timeout=2s]', 'rediss://...'\navailable schemes: "+strings.Join(cache.Schemes(), ", "))
 	flag.BoolVar(&app.disableSingleflight, "disableSingleflight", false, "disable singleflight")
 	flag.BoolVar(&app.concurrent, "concurrent", false, "concurrent requests")
 	flag.BoolVar(&app.debug, "debug", false, "enable debug logging")
@@ -65,6 +73,9 @@ func main() {
 		ClientID:            app.clientID,
 		ClientSecret:        app.clientSecret,
 		Scope:               app.scope,
+		AuthMethod:          clientcredentials.AuthMethod(app.authMethod),
+		Audience:            app.audience,
+		Resource:            splitList(app.resource),
 		SoftExpireInSeconds: app.softExpireSeconds,
 		Cache:               cache,
 		DisableSingleFlight: app.disableSingleflight,
@@ -107,6 +118,14 @@ func send(app *application, client *clientcredentials.Client, i int) {
 
 	resp, errDo := client.Do(req)
 	if errDo != nil {
+		var tokenErr *clientcredentials.TokenError
+		if errors.As(errDo, &tokenErr) {
+			log.Printf("%s: token error: status: %d", label, tokenErr.StatusCode)
+			log.Printf("%s: token error: error: %s", label, tokenErr.Code)
+			log.Printf("%s: token error: error_description: %s", label, tokenErr.Description)
+			log.Printf("%s: token error: error_uri: %s", label, tokenErr.URI)
+			log.Printf("%s: token error: body: %s", label, tokenErr.Body)
+		}
 		log.Fatalf("%s: do: %v", label, errDo)
 	}
 	defer resp.Body.Close()
@@ -126,3 +145,14 @@ func send(app *application, client *clientcredentials.Client, i int) {
 		time.Sleep(app.interval)
 	}
 }
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
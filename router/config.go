@@ -0,0 +1,78 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig loads the routes from a file. Files with extension .json
+// are decoded as JSON, any other as YAML.
+//
+// YAML example:
+//
+//	routes:
+//	  - name: orders
+//	    host: orders.example.com
+//	    token_url: https://auth.example.com/oauth/token
+//	    client_id: orders-client
+//	    client_secret: secret
+//	    scope: orders:read
+//	    audience_from_destination: true
+//	  - name: billing
+//	    host: "*.billing.example.com"
+//	    path_prefix: /v2/
+//	    token_url: https://billing-auth.example.com/token
+//	    client_id: billing-client
+//	    client_secret: secret
+//	    cache: redis://localhost:6379/0?key=billing-token
+func LoadConfig(filename string) (Config, error) {
+	data, errRead := os.ReadFile(filename)
+	if errRead != nil {
+		return Config{}, errRead
+	}
+
+	var config Config
+	var err error
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		config, err = DecodeJSON(data)
+	} else {
+		config, err = DecodeYAML(data)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return config, nil
+}
+
+// DecodeJSON decodes the routes from JSON, rejecting unknown fields.
+func DecodeJSON(data []byte) (Config, error) {
+	var config Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("router config json: %w", err)
+	}
+	return config, nil
+}
+
+// DecodeYAML decodes the routes from YAML, rejecting unknown fields.
+// Empty input decodes as empty configuration.
+func DecodeYAML(data []byte) (Config, error) {
+	var config Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("router config yaml: %w", err)
+	}
+	return config, nil
+}
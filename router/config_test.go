@@ -0,0 +1,136 @@
+package router
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const configYAML = `
+routes:
+  - name: orders
+    host: orders.example.com
+    token_url: https://auth.example.com/oauth/token
+    client_id: orders-client
+    client_secret: secret
+    scope: orders:read
+    audience_from_destination: true
+  - name: billing
+    host: "*.billing.example.com"
+    path_prefix: /v2/
+    token_url: https://billing-auth.example.com/token
+    client_id: billing-client
+    client_secret: secret
+    auth_method: client_secret_basic
+    resource:
+      - https://billing.example.com
+`
+
+const configJSON = `{
+  "routes": [
+    {
+      "name": "orders",
+      "host": "orders.example.com",
+      "token_url": "https://auth.example.com/oauth/token",
+      "client_id": "orders-client",
+      "client_secret": "secret",
+      "scope": "orders:read",
+      "audience_from_destination": true
+    },
+    {
+      "name": "billing",
+      "host": "*.billing.example.com",
+      "path_prefix": "/v2/",
+      "token_url": "https://billing-auth.example.com/token",
+      "client_id": "billing-client",
+      "client_secret": "secret",
+      "auth_method": "client_secret_basic",
+      "resource": ["https://billing.example.com"]
+    }
+  ]
+}`
+
+var expectedRoutes = []Route{
+	{
+		Name:                    "orders",
+		Host:                    "orders.example.com",
+		TokenURL:                "https://auth.example.com/oauth/token",
+		ClientID:                "orders-client",
+		ClientSecret:            "secret",
+		Scope:                   "orders:read",
+		AudienceFromDestination: true,
+	},
+	{
+		Name:         "billing",
+		Host:         "*.billing.example.com",
+		PathPrefix:   "/v2/",
+		TokenURL:     "https://billing-auth.example.com/token",
+		ClientID:     "billing-client",
+		ClientSecret: "secret",
+		AuthMethod:   "client_secret_basic",
+		Resource:     []string{"https://billing.example.com"},
+	},
+}
+
+// go test -run TestLoadConfig -count 1 ./router
+func TestLoadConfig(t *testing.T) {
+
+	dir := t.TempDir()
+
+	testCases := []struct {
+		filename string
+		data     string
+	}{
+		{"routes.yaml", configYAML},
+		{"routes.yml", configYAML},
+		{"routes.json", configJSON},
+		{"routes.JSON", configJSON},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			filename := filepath.Join(dir, tc.filename)
+			if err := os.WriteFile(filename, []byte(tc.data), 0o600); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			config, errLoad := LoadConfig(filename)
+			if errLoad != nil {
+				t.Fatalf("load: %v", errLoad)
+			}
+
+			if fmt.Sprintf("%+v", config.Routes) != fmt.Sprintf("%+v", expectedRoutes) {
+				t.Errorf("unexpected routes:\n%+v\nexpected:\n%+v", config.Routes, expectedRoutes)
+			}
+
+			if _, errRouter := New(config); errRouter != nil {
+				t.Errorf("router: %v", errRouter)
+			}
+		})
+	}
+}
+
+// go test -run TestDecodeConfigError -count 1 ./router
+func TestDecodeConfigError(t *testing.T) {
+
+	if _, err := DecodeYAML([]byte("routes:\n  - hostname: api.example.com\n")); err == nil {
+		t.Errorf("expected error for unknown yaml field")
+	}
+
+	if _, err := DecodeJSON([]byte(`{"routes":[{"hostname":"api.example.com"}]}`)); err == nil {
+		t.Errorf("expected error for unknown json field")
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	config, errEmpty := DecodeYAML(nil)
+	if errEmpty != nil {
+		t.Errorf("empty yaml: %v", errEmpty)
+	}
+	if len(config.Routes) != 0 {
+		t.Errorf("unexpected routes: %+v", config.Routes)
+	}
+}
@@ -0,0 +1,252 @@
+// Package router implements an http.RoundTripper that selects the
+// client credentials for every request by its destination.
+//
+// Example:
+//
+//	config, errConfig := router.LoadConfig("routes.yaml")
+//	if errConfig != nil {
+//	    log.Fatalf("routes: %v", errConfig)
+//	}
+//
+//	r, errRouter := router.New(config)
+//	if errRouter != nil {
+//	    log.Fatalf("router: %v", errRouter)
+//	}
+//
+//	httpClient := &http.Client{Transport: r}
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/udhos/oauth2/cache"
+	"github.com/udhos/oauth2/clientcredentials"
+)
+
+// Config defines the routes.
+type Config struct {
+	// Routes are matched in order: the first route matching the request
+	// provides the token. Requests matching no route are sent without
+	// any token.
+	Routes []Route `json:"routes" yaml:"routes"`
+
+	// ClientOptions is the template for the client of every route.
+	// Route fields replace TokenURL, ClientID, ClientSecret, Scope,
+	// AuthMethod, Audience and Resource. Cache and CacheV2 are ignored,
+	// since routes sharing a cache would mix up their tokens: see
+	// Route.Cache.
+	ClientOptions clientcredentials.Options `json:"-" yaml:"-"`
+
+	// Base is the underlying transport used to send the requests.
+	// If nil, http.DefaultTransport is used, except for routes in mTLS
+	// mode, whose clients provide a transport presenting the client
+	// certificate.
+	Base http.RoundTripper `json:"-" yaml:"-"`
+}
+
+// Route maps the matching requests to client credentials.
+// A request matches the route when it matches all of Host, PathPrefix
+// and Match that are defined. At least one of them is required, and
+// PathPrefix requires Host, since a path alone would match any host.
+// A route with Match alone sends its token to any host the matcher
+// accepts.
+type Route struct {
+	// Name identifies the route in errors.
+	Name string `json:"name" yaml:"name"`
+
+	// Host matches the request host. Without port, as in
+	// "api.example.com", any port matches. With port, as in
+	// "api.example.com:8443", the port must match as well.
+	// The wildcard "*.example.com" matches any subdomain of example.com.
+	Host string `json:"host" yaml:"host"`
+
+	// PathPrefix matches the beginning of the request path.
+	// Requires Host.
+	PathPrefix string `json:"path_prefix" yaml:"path_prefix"`
+
+	// Match is a custom matcher. Since it cannot be loaded from a file,
+	// set it in code, for instance looking up the route by Name.
+	Match func(req *http.Request) bool `json:"-" yaml:"-"`
+
+	TokenURL     string   `json:"token_url" yaml:"token_url"`
+	ClientID     string   `json:"client_id" yaml:"client_id"`
+	ClientSecret string   `json:"client_secret" yaml:"client_secret"`
+	Scope        string   `json:"scope" yaml:"scope"`
+	AuthMethod   string   `json:"auth_method" yaml:"auth_method"`
+	Audience     string   `json:"audience" yaml:"audience"`
+	Resource     []string `json:"resource" yaml:"resource"`
+
+	// AudienceFromDestination derives the audience from the request
+	// destination, as in "https://api.example.com", taking precedence
+	// over Audience.
+	AudienceFromDestination bool `json:"audience_from_destination" yaml:"audience_from_destination"`
+
+	// Cache is the cache specification, as accepted by cache.New.
+	// Empty means the client's default memory cache.
+	Cache string `json:"cache" yaml:"cache"`
+}
+
+// Router is an http.RoundTripper that authenticates every request with
+// the client credentials of the first matching route. Redirects are
+// routed again, hence a token is sent only to destinations matching its
+// route.
+type Router struct {
+	routes []route
+	base   http.RoundTripper
+}
+
+type route struct {
+	Route
+	host      string // without wildcard
+	hasPort   bool
+	wildcard  bool
+	transport *clientcredentials.Transport
+	cache     io.Closer // nil if the cache holds no resources
+}
+
+// New creates a router.
+func New(config Config) (*Router, error) {
+	r := &Router{base: config.Base}
+	if r.base == nil {
+		r.base = http.DefaultTransport
+	}
+
+	for i, rt := range config.Routes {
+		name := rt.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if rt.Host == "" && rt.PathPrefix == "" && rt.Match == nil {
+			return r.fail(fmt.Errorf("route %s: missing host, path_prefix or matcher", name))
+		}
+		if rt.Host == "" && rt.PathPrefix != "" {
+			return r.fail(fmt.Errorf("route %s: path_prefix requires host", name))
+		}
+		if rt.TokenURL == "" {
+			return r.fail(fmt.Errorf("route %s: missing token_url", name))
+		}
+
+		host, wildcard := strings.CutPrefix(strings.ToLower(rt.Host), "*")
+		if wildcard && !strings.HasPrefix(host, ".") {
+			return r.fail(fmt.Errorf("route %s: bad host wildcard: %q", name, rt.Host))
+		}
+		_, _, errPort := net.SplitHostPort(host)
+
+		c, errCache := cache.New(rt.Cache, rt.TokenURL, rt.ClientID)
+		if errCache != nil {
+			return r.fail(fmt.Errorf("route %s: %w", name, errCache))
+		}
+		closer, _ := c.(io.Closer)
+
+		options := config.ClientOptions
+		options.TokenURL = rt.TokenURL
+		options.ClientID = rt.ClientID
+		options.ClientSecret = rt.ClientSecret
+		options.Scope = rt.Scope
+		options.AuthMethod = clientcredentials.AuthMethod(rt.AuthMethod)
+		options.Audience = rt.Audience
+		options.Resource = rt.Resource
+		options.Cache = c
+		options.CacheV2 = nil
+
+		r.routes = append(r.routes, route{
+			Route:    rt,
+			host:     host,
+			hasPort:  errPort == nil,
+			wildcard: wildcard,
+			transport: &clientcredentials.Transport{
+				Client: clientcredentials.New(options),
+				Base:   config.Base,
+			},
+			cache: closer,
+		})
+	}
+
+	return r, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Router) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := r.match(req)
+	if rt == nil {
+		// no route: send without token
+		return r.base.RoundTrip(req)
+	}
+
+	if rt.AudienceFromDestination {
+		ctx := clientcredentials.WithTokenRequest(req.Context(),
+			clientcredentials.TokenRequest{Audience: destination(req)})
+		req = req.WithContext(ctx)
+	}
+
+	return rt.transport.RoundTrip(req)
+}
+
+// Close stops the background refresher of every route client, then
+// closes the route caches holding resources, like redis connections.
+func (r *Router) Close(ctx context.Context) error {
+	var errs []error
+	for _, rt := range r.routes {
+		errs = append(errs, rt.transport.Client.Close(ctx))
+		if rt.cache != nil {
+			errs = append(errs, rt.cache.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fail releases the routes created so far by New.
+func (r *Router) fail(err error) (*Router, error) {
+	r.Close(context.Background())
+	return nil, err
+}
+
+// match finds the first route matching the request.
+func (r *Router) match(req *http.Request) *route {
+	for i := range r.routes {
+		if rt := &r.routes[i]; rt.matches(req) {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (rt *route) matches(req *http.Request) bool {
+	if rt.host != "" && !rt.matchHost(req) {
+		return false
+	}
+	if rt.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, rt.PathPrefix) {
+		return false
+	}
+	if rt.Match != nil && !rt.Match(req) {
+		return false
+	}
+	return true
+}
+
+// matchHost matches the request host, with port if the route host has
+// port, or just the hostname otherwise.
+func (rt *route) matchHost(req *http.Request) bool {
+	host := req.URL.Hostname()
+	if rt.hasPort {
+		host = req.URL.Host
+	}
+	host = strings.ToLower(host)
+	if rt.wildcard {
+		return strings.HasSuffix(host, rt.host) // rt.host holds the leading dot
+	}
+	return host == rt.host
+}
+
+// destination is the request scheme and host, as in
+// "https://api.example.com".
+func destination(req *http.Request) string {
+	return req.URL.Scheme + "://" + req.URL.Host
+}
@@ -0,0 +1,273 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/udhos/oauth2/cache"
+	"github.com/udhos/oauth2/token"
+)
+
+// go test -run TestRouter -count 1 ./router
+func TestRouter(t *testing.T) {
+
+	ts := newTokenServer()
+	defer ts.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a/redirect" {
+			http.Redirect(w, r, "/other", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	srvURL, _ := url.Parse(srv.URL)
+
+	r, errRouter := New(Config{
+		Routes: []Route{
+			{
+				Name:         "a",
+				Host:         srvURL.Host,
+				PathPrefix:   "/a/",
+				TokenURL:     ts.URL,
+				ClientID:     "client-a",
+				ClientSecret: "secret-a",
+			},
+			{
+				Name:                    "b",
+				Host:                    srvURL.Host,
+				PathPrefix:              "/b/",
+				TokenURL:                ts.URL,
+				ClientID:                "client-b",
+				ClientSecret:            "secret-b",
+				Audience:                "ignored",
+				AudienceFromDestination: true,
+			},
+			{
+				Name:         "c",
+				Match:        func(req *http.Request) bool { return req.Header.Get("X-Route") == "c" },
+				TokenURL:     ts.URL,
+				ClientID:     "client-c",
+				ClientSecret: "secret-c",
+				Audience:     "aud-c",
+			},
+		},
+	})
+	if errRouter != nil {
+		t.Fatalf("router: %v", errRouter)
+	}
+	defer r.Close(context.TODO())
+
+	httpClient := &http.Client{Transport: r}
+
+	testCases := []struct {
+		name          string
+		path          string
+		route         string
+		expectedToken string
+	}{
+		{"host and path", "/a/x", "", "Bearer client-a|"},
+		{"first route wins", "/a/x", "c", "Bearer client-a|"},
+		{"audience from destination", "/b/x", "", "Bearer client-b|" + srv.URL},
+		{"custom matcher", "/other", "c", "Bearer client-c|aud-c"},
+		{"no route", "/other", "", ""},
+		{"prefix mismatch", "/a", "", ""},
+		{"redirect out of route", "/a/redirect", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, errReq := http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL+tc.path, nil)
+			if errReq != nil {
+				t.Fatalf("request: %v", errReq)
+			}
+			if tc.route != "" {
+				req.Header.Set("X-Route", tc.route)
+			}
+
+			body := do(t, httpClient, req)
+
+			if body != tc.expectedToken {
+				t.Errorf("expected token '%s', got '%s'", tc.expectedToken, body)
+			}
+		})
+	}
+}
+
+// go test -run TestRouterMatchHost -count 1 ./router
+func TestRouterMatchHost(t *testing.T) {
+
+	testCases := []struct {
+		routeHost string
+		url       string
+		expected  bool
+	}{
+		{"api.example.com", "https://api.example.com/x", true},
+		{"api.example.com", "https://API.example.com:8443/x", true},
+		{"api.example.com", "https://other.example.com/x", false},
+		{"api.example.com:8443", "https://api.example.com:8443/x", true},
+		{"api.example.com:8443", "https://api.example.com/x", false},
+		{"*.example.com", "https://api.example.com/x", true},
+		{"*.example.com", "https://a.b.example.com:8443/x", true},
+		{"*.example.com", "https://example.com/x", false},
+		{"*.example.com", "https://badexample.com/x", false},
+		{"[::1]:8080", "http://[::1]:8080/x", true},
+		{"::1", "http://[::1]:8080/x", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.routeHost+" "+tc.url, func(t *testing.T) {
+			r, errRouter := New(Config{
+				Routes: []Route{{Host: tc.routeHost, TokenURL: "http://token"}},
+			})
+			if errRouter != nil {
+				t.Fatalf("router: %v", errRouter)
+			}
+			req, errReq := http.NewRequest(http.MethodGet, tc.url, nil)
+			if errReq != nil {
+				t.Fatalf("request: %v", errReq)
+			}
+			if matched := r.match(req) != nil; matched != tc.expected {
+				t.Errorf("expected match=%t, got %t", tc.expected, matched)
+			}
+		})
+	}
+}
+
+// go test -run TestRouterConfigError -count 1 ./router
+func TestRouterConfigError(t *testing.T) {
+
+	testCases := []struct {
+		name  string
+		route Route
+	}{
+		{"missing matcher", Route{TokenURL: "http://token"}},
+		{"path prefix without host", Route{PathPrefix: "/v2/", TokenURL: "http://token"}},
+		{"missing token url", Route{Host: "api.example.com"}},
+		{"bad wildcard", Route{Host: "*example.com", TokenURL: "http://token"}},
+		{"bad cache", Route{Host: "api.example.com", TokenURL: "http://token", Cache: "unknown:x"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errRouter := New(Config{Routes: []Route{tc.route}})
+			if errRouter == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+// go test -run TestRouterCloseCache -count 1 ./router
+func TestRouterCloseCache(t *testing.T) {
+
+	r, errRouter := New(Config{
+		Routes: []Route{
+			{Host: "a.example.com", TokenURL: "http://token", Cache: "test-closer:close-a"},
+			{Host: "b.example.com", TokenURL: "http://token"},
+		},
+	})
+	if errRouter != nil {
+		t.Fatalf("router: %v", errRouter)
+	}
+
+	if closed("close-a") {
+		t.Errorf("cache closed before router")
+	}
+	if errClose := r.Close(context.TODO()); errClose != nil {
+		t.Errorf("close: %v", errClose)
+	}
+	if !closed("close-a") {
+		t.Errorf("cache not closed by router")
+	}
+}
+
+// go test -run TestRouterConfigErrorCloseCache -count 1 ./router
+func TestRouterConfigErrorCloseCache(t *testing.T) {
+
+	_, errRouter := New(Config{
+		Routes: []Route{
+			{Host: "a.example.com", TokenURL: "http://token", Cache: "test-closer:fail-a"},
+			{Host: "b.example.com"}, // missing token url
+		},
+	})
+	if errRouter == nil {
+		t.Fatalf("expected error")
+	}
+	if !closed("fail-a") {
+		t.Errorf("cache of previous route not closed on error")
+	}
+}
+
+func init() {
+	cache.Register("test-closer", func(options cache.Options) (token.TokenCache, error) {
+		c := &closerCache{}
+		closerCaches.Store(options.Rest, c)
+		return c, nil
+	})
+}
+
+// closerCaches maps the spec rest to the closerCache created for it.
+var closerCaches sync.Map
+
+// closerCache is a dummy cache recording whether it was closed.
+type closerCache struct {
+	closed atomic.Bool
+}
+
+func (c *closerCache) Get() (token.Token, error) { return token.Token{}, nil }
+func (c *closerCache) Put(token.Token) error     { return nil }
+func (c *closerCache) Expire() error             { return nil }
+
+func (c *closerCache) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func closed(name string) bool {
+	c, found := closerCaches.Load(name)
+	return found && c.(*closerCache).closed.Load()
+}
+
+func do(t *testing.T, httpClient *http.Client, req *http.Request) string {
+	t.Helper()
+	resp, errDo := httpClient.Do(req)
+	if errDo != nil {
+		t.Fatalf("do: %v", errDo)
+	}
+	defer resp.Body.Close()
+	body, errBody := io.ReadAll(resp.Body)
+	if errBody != nil {
+		t.Fatalf("body: %v", errBody)
+	}
+	return string(body)
+}
+
+// newTokenServer creates a token server that issues the token
+// "<client_id>|<audience>" for client secret "secret-<suffix>" of client
+// id "client-<suffix>".
+func newTokenServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID := r.Form.Get("client_id")
+		suffix, _ := strings.CutPrefix(clientID, "client-")
+		if r.Form.Get("client_secret") != "secret-"+suffix {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"%s|%s","expires_in":300}`, clientID, r.Form.Get("audience"))
+	}))
+}
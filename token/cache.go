@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"sync"
 )
 
@@ -11,35 +12,106 @@ type TokenCache interface {
 	Expire() error
 }
 
+// TokenCacheCompareExpire is an optional extension to TokenCache.
+// Caches implementing it allow the client to invalidate a token refused
+// by the server without discarding a newer token that might have been
+// renewed concurrently.
+type TokenCacheCompareExpire interface {
+	// CompareAndExpire atomically expires the cached token only if its
+	// value matches the given value. It reports whether the token was
+	// expired.
+	CompareAndExpire(value string) (bool, error)
+}
+
 // memoryCache implements a memory cache.
+// It implements both TokenCache and TokenCacheV2.
+// TokenCache methods operate on the default key "".
 type memoryCache struct {
-	t     Token
-	mutex sync.Mutex
+	tokens map[string]Token
+	mutex  sync.Mutex
 }
 
 // Get retrieves token from cache.
 func (mc *memoryCache) Get() (Token, error) {
+	return mc.GetToken(context.TODO(), "")
+}
+
+// Put inserts token into cache.
+func (mc *memoryCache) Put(t Token) error {
+	return mc.PutToken(context.TODO(), "", t)
+}
+
+// Expire invalidates token in cache.
+func (mc *memoryCache) Expire() error {
+	return mc.ExpireToken(context.TODO(), "")
+}
+
+// CompareAndExpire expires token in cache only if it holds the given value.
+func (mc *memoryCache) CompareAndExpire(value string) (bool, error) {
+	return mc.CompareAndExpireToken(context.TODO(), "", value)
+}
+
+// GetToken retrieves token from cache.
+func (mc *memoryCache) GetToken(_ context.Context, key string) (Token, error) {
 	mc.mutex.Lock()
-	t := mc.t
+	t, found := mc.tokens[key]
 	mc.mutex.Unlock()
+	if !found {
+		t.Expire() // missing token is reported as expired
+	}
 	return t, nil
 }
 
-// Put inserts token into cache.
-func (mc *memoryCache) Put(t Token) error {
+// PutToken inserts token into cache.
+func (mc *memoryCache) PutToken(_ context.Context, key string, t Token) error {
 	mc.mutex.Lock()
-	mc.t = t
+	if mc.tokens == nil {
+		mc.tokens = map[string]Token{}
+	}
+	mc.tokens[key] = t
 	mc.mutex.Unlock()
 	return nil
 }
 
-// Expire invalidates token in cache.
-func (mc *memoryCache) Expire() error {
+// ExpireToken invalidates token in cache.
+func (mc *memoryCache) ExpireToken(_ context.Context, key string) error {
+	mc.mutex.Lock()
+	if t, found := mc.tokens[key]; found {
+		t.Expire()
+		mc.tokens[key] = t
+	}
+	mc.mutex.Unlock()
+	return nil
+}
+
+// DeleteToken removes token from cache.
+func (mc *memoryCache) DeleteToken(_ context.Context, key string) error {
 	mc.mutex.Lock()
-	mc.t.Expire()
+	delete(mc.tokens, key)
 	mc.mutex.Unlock()
 	return nil
 }
 
-// DefaultTokenCache provides default implementation for token cache.
+// CompareAndExpireToken expires token in cache only if it holds the given value.
+func (mc *memoryCache) CompareAndExpireToken(_ context.Context, key, value string) (bool, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	t, found := mc.tokens[key]
+	if !found || t.Value != value {
+		return false, nil
+	}
+	t.Expire()
+	mc.tokens[key] = t
+	return true, nil
+}
+
+// NewMemoryCache creates a memory cache.
+// The cache implements both TokenCache and TokenCacheV2.
+func NewMemoryCache() TokenCache {
+	return &memoryCache{}
+}
+
+// DefaultTokenCache provides a memory cache shared by every user of it.
+// Clients only share it when they explicitly set it as their cache,
+// otherwise each client creates its own memory cache.
 var DefaultTokenCache = &memoryCache{}
@@ -0,0 +1,49 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheCompareAndExpire(t *testing.T) {
+	mc := &memoryCache{}
+
+	now := time.Now()
+
+	tk := Token{Value: "new"}
+	tk.SetExpiration(now.Add(time.Minute))
+
+	if err := mc.Put(tk); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// late refusal for old token must not expire new token
+
+	expired, errExpire := mc.CompareAndExpire("old")
+	if errExpire != nil {
+		t.Errorf("compare-and-expire: %v", errExpire)
+	}
+	if expired {
+		t.Errorf("unexpected expiration for old token")
+	}
+
+	cached, _ := mc.Get()
+	if !cached.IsValid(now, 0, t.Logf) {
+		t.Errorf("new token was expired by old token refusal")
+	}
+
+	// refusal for current token expires it
+
+	expired, errExpire = mc.CompareAndExpire("new")
+	if errExpire != nil {
+		t.Errorf("compare-and-expire: %v", errExpire)
+	}
+	if !expired {
+		t.Errorf("current token was not expired")
+	}
+
+	cached, _ = mc.Get()
+	if cached.IsValid(now, 0, t.Logf) {
+		t.Errorf("current token still valid after expiration")
+	}
+}
@@ -0,0 +1,102 @@
+package token
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenCacheV2 defines a context-aware cache interface for storing
+// tokens under keys. A single cache may hold many tokens, one per key.
+//
+// Method names are distinct from TokenCache, so that a cache
+// implementation can support both interfaces.
+type TokenCacheV2 interface {
+	// GetToken retrieves token from cache.
+	GetToken(ctx context.Context, key string) (Token, error)
+
+	// PutToken inserts token into cache.
+	PutToken(ctx context.Context, key string, t Token) error
+
+	// ExpireToken invalidates token in cache, keeping it in the cache.
+	ExpireToken(ctx context.Context, key string) error
+
+	// DeleteToken removes token from cache.
+	DeleteToken(ctx context.Context, key string) error
+}
+
+// TokenCacheV2CompareExpire is an optional extension to TokenCacheV2.
+// See TokenCacheCompareExpire.
+type TokenCacheV2CompareExpire interface {
+	// CompareAndExpireToken atomically expires the cached token only if
+	// its value matches the given value. It reports whether the token
+	// was expired.
+	CompareAndExpireToken(ctx context.Context, key, value string) (bool, error)
+}
+
+// ErrKeyUnsupported is returned by the TokenCache adapter for keys other
+// than the default key "", since TokenCache holds a single token.
+var ErrKeyUnsupported = errors.New("token cache: TokenCache supports only default key")
+
+// AdaptTokenCache adapts TokenCache to TokenCacheV2.
+// If the cache already implements TokenCacheV2, it is returned as is.
+//
+// Since TokenCache holds a single token and is not aware of context,
+// the adapter ignores the context and only supports the default key "",
+// returning ErrKeyUnsupported for other keys.
+// Delete is performed by overwriting the token with an empty one.
+func AdaptTokenCache(c TokenCache) TokenCacheV2 {
+	if v2, ok := c.(TokenCacheV2); ok {
+		return v2
+	}
+	return &cacheAdapter{c: c}
+}
+
+// cacheAdapter adapts TokenCache to TokenCacheV2.
+type cacheAdapter struct {
+	c TokenCache
+}
+
+// GetToken retrieves token from cache.
+func (a *cacheAdapter) GetToken(_ context.Context, key string) (Token, error) {
+	if key != "" {
+		return Token{}, ErrKeyUnsupported
+	}
+	return a.c.Get()
+}
+
+// PutToken inserts token into cache.
+func (a *cacheAdapter) PutToken(_ context.Context, key string, t Token) error {
+	if key != "" {
+		return ErrKeyUnsupported
+	}
+	return a.c.Put(t)
+}
+
+// ExpireToken invalidates token in cache.
+func (a *cacheAdapter) ExpireToken(_ context.Context, key string) error {
+	if key != "" {
+		return ErrKeyUnsupported
+	}
+	return a.c.Expire()
+}
+
+// DeleteToken removes token from cache.
+func (a *cacheAdapter) DeleteToken(_ context.Context, key string) error {
+	if key != "" {
+		return ErrKeyUnsupported
+	}
+	return a.c.Put(Token{Expirable: true})
+}
+
+// CompareAndExpireToken expires token in cache only if it holds the given value.
+// If the underlying cache does not implement TokenCacheCompareExpire,
+// the token is expired unconditionally.
+func (a *cacheAdapter) CompareAndExpireToken(_ context.Context, key, value string) (bool, error) {
+	if key != "" {
+		return false, ErrKeyUnsupported
+	}
+	if cae, ok := a.c.(TokenCacheCompareExpire); ok {
+		return cae.CompareAndExpire(value)
+	}
+	return true, a.c.Expire()
+}
@@ -0,0 +1,109 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheKeys(t *testing.T) {
+	mc := &memoryCache{}
+	ctx := context.TODO()
+	now := time.Now()
+
+	tkA := Token{Value: "a"}
+	tkA.SetExpiration(now.Add(time.Minute))
+
+	tkB := Token{Value: "b"}
+	tkB.SetExpiration(now.Add(time.Minute))
+
+	if err := mc.PutToken(ctx, "keyA", tkA); err != nil {
+		t.Fatalf("put A: %v", err)
+	}
+	if err := mc.PutToken(ctx, "keyB", tkB); err != nil {
+		t.Fatalf("put B: %v", err)
+	}
+
+	if got, _ := mc.GetToken(ctx, "keyA"); got.Value != "a" {
+		t.Errorf("key A: unexpected token: %s", got.Value)
+	}
+	if got, _ := mc.GetToken(ctx, "keyB"); got.Value != "b" {
+		t.Errorf("key B: unexpected token: %s", got.Value)
+	}
+
+	if got, _ := mc.GetToken(ctx, "missing"); got.IsValid(now, 0, t.Logf) {
+		t.Errorf("missing key: unexpected valid token")
+	}
+
+	if err := mc.ExpireToken(ctx, "keyA"); err != nil {
+		t.Errorf("expire A: %v", err)
+	}
+	if got, _ := mc.GetToken(ctx, "keyA"); got.IsValid(now, 0, t.Logf) {
+		t.Errorf("key A: token still valid after expiration")
+	}
+	if got, _ := mc.GetToken(ctx, "keyB"); !got.IsValid(now, 0, t.Logf) {
+		t.Errorf("key B: token expired by expiration of key A")
+	}
+
+	if err := mc.DeleteToken(ctx, "keyB"); err != nil {
+		t.Errorf("delete B: %v", err)
+	}
+	if got, _ := mc.GetToken(ctx, "keyB"); got.IsValid(now, 0, t.Logf) {
+		t.Errorf("key B: token still valid after delete")
+	}
+}
+
+// legacyCache implements only TokenCache.
+type legacyCache struct {
+	t Token
+}
+
+func (c *legacyCache) Get() (Token, error) { return c.t, nil }
+func (c *legacyCache) Put(t Token) error   { c.t = t; return nil }
+func (c *legacyCache) Expire() error       { c.t.Expire(); return nil }
+
+func TestAdaptTokenCache(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+
+	if _, isAdapter := AdaptTokenCache(NewMemoryCache()).(*cacheAdapter); isAdapter {
+		t.Errorf("memory cache implements TokenCacheV2 and must not be adapted")
+	}
+
+	legacy := &legacyCache{}
+	adapter := AdaptTokenCache(legacy)
+
+	tk := Token{Value: "abc"}
+	tk.SetExpiration(now.Add(time.Minute))
+
+	if err := adapter.PutToken(ctx, "", tk); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if legacy.t.Value != "abc" {
+		t.Errorf("put not forwarded to legacy cache")
+	}
+
+	if got, _ := adapter.GetToken(ctx, ""); got.Value != "abc" {
+		t.Errorf("unexpected token: %s", got.Value)
+	}
+
+	if _, err := adapter.GetToken(ctx, "other"); !errors.Is(err, ErrKeyUnsupported) {
+		t.Errorf("expected ErrKeyUnsupported, got: %v", err)
+	}
+
+	cae := adapter.(TokenCacheV2CompareExpire)
+	if expired, _ := cae.CompareAndExpireToken(ctx, "", "abc"); !expired {
+		t.Errorf("compare-and-expire did not expire token")
+	}
+	if legacy.t.IsValid(now, 0, t.Logf) {
+		t.Errorf("legacy token still valid after expiration")
+	}
+
+	if err := adapter.DeleteToken(ctx, ""); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if got, _ := adapter.GetToken(ctx, ""); got.Value != "" || got.IsValid(now, 0, t.Logf) {
+		t.Errorf("token still present after delete: %v", got)
+	}
+}
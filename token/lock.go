@@ -0,0 +1,48 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TokenCacheLocker is an optional extension to TokenCacheV2 implemented
+// by caches shared by many processes. It provides a distributed lock, so
+// that only one process fetches a new token from the token server, while
+// the others wait for the new token to appear in the cache.
+type TokenCacheLocker interface {
+	// TryLockToken attempts to acquire the lock for fetching the token
+	// under key. It returns nil TokenLock, with nil error, if the lock is
+	// held by another process. It returns ErrLockUnsupported if locking
+	// is not enabled for the cache.
+	TryLockToken(ctx context.Context, key string) (TokenLock, error)
+
+	// LockPollInterval is how long to wait before checking the cache
+	// again while the lock is held by another process.
+	LockPollInterval() time.Duration
+}
+
+// TokenLock is a distributed lock acquired with TokenCacheLocker.
+// The lock expires automatically, so that if its holder dies, another
+// process can take over.
+type TokenLock interface {
+	// Fence returns the fencing token: a number that increases on
+	// every lock acquisition.
+	Fence() int64
+
+	// PutToken inserts token into cache only if the lock is still held.
+	// It returns ErrLockLost if the lock has expired and possibly been
+	// acquired by another process.
+	PutToken(ctx context.Context, t Token) error
+
+	// Unlock releases the lock, if still held.
+	Unlock(ctx context.Context) error
+}
+
+var (
+	// ErrLockUnsupported means the cache does not provide locking.
+	ErrLockUnsupported = errors.New("token cache: lock unsupported")
+
+	// ErrLockLost means the lock has expired.
+	ErrLockLost = errors.New("token cache: lock lost")
+)
@@ -18,6 +18,10 @@ type Token struct {
 	// refused it and a new one must be retrieved.
 	//
 	Expirable bool `json:"expirable"`
+
+	// Type is the token_type returned by the token server, like "Bearer".
+	// Empty means Bearer.
+	Type string `json:"type,omitempty"`
 }
 
 // NewTokenFromJSON creates token from json.
@@ -44,6 +48,14 @@ func (t *Token) IsValid(now time.Time, softExpire time.Duration, debugf func(for
 	return valid
 }
 
+// IsHardValid checks whether token has not reached its hard deadline,
+// disregarding the soft expire window. A token that is hard valid but
+// not valid (see IsValid) is stale: it is still accepted by the server,
+// but it is due for renewal.
+func (t *Token) IsHardValid(now time.Time) bool {
+	return !t.Expirable || t.Deadline.After(now)
+}
+
 // Expire expires the token.
 func (t *Token) Expire() {
 	t.Expirable = true
@@ -9,6 +9,7 @@ func TestToken(t *testing.T) {
 	tk := Token{
 		Value:    "abc",
 		Deadline: time.Now(),
+		Type:     "DPoP",
 	}
 
 	buf, errJSON := tk.ExportJSON()
@@ -25,6 +26,10 @@ func TestToken(t *testing.T) {
 		t.Errorf("value: '%s' != '%s'", tk.Value, tk2.Value)
 	}
 
+	if tk.Type != tk2.Type {
+		t.Errorf("type: '%s' != '%s'", tk.Type, tk2.Type)
+	}
+
 	if tk.Expirable != tk2.Expirable {
 		t.Errorf("expirable: %t != %t'", tk.Expirable, tk2.Expirable)
 	}
@@ -33,3 +38,31 @@ func TestToken(t *testing.T) {
 		t.Errorf("deadline: %v != %v'", tk.Deadline, tk2.Deadline)
 	}
 }
+
+func TestTokenStale(t *testing.T) {
+	now := time.Now()
+	softExpire := 10 * time.Second
+
+	tk := Token{Value: "abc"}
+	tk.SetExpiration(now.Add(5 * time.Second))
+
+	if tk.IsValid(now, softExpire, func(string, ...any) {}) {
+		t.Errorf("token within soft expire window should not be valid")
+	}
+	if !tk.IsHardValid(now) {
+		t.Errorf("token before deadline should be hard valid")
+	}
+	if tk.IsHardValid(now.Add(6 * time.Second)) {
+		t.Errorf("token after deadline should not be hard valid")
+	}
+
+	tk.Expire()
+	if tk.IsHardValid(now) {
+		t.Errorf("expired token should not be hard valid")
+	}
+
+	var nonExpirable Token
+	if !nonExpirable.IsHardValid(now) {
+		t.Errorf("non-expirable token should be hard valid")
+	}
+}